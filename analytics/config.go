@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the analytics consumer settings. It reads the kafka section
+// of the same YAML file the game server uses, so one file configures both.
+type Config struct {
+	Kafka KafkaConfig `yaml:"kafka"`
+}
+
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Group   string   `yaml:"group"`
+	Topics  []string `yaml:"topics"`
+}
+
+// DefaultConfig returns the settings the consumer used before they were configurable
+func DefaultConfig() Config {
+	return Config{
+		Kafka: KafkaConfig{
+			Brokers: []string{"localhost:9092"},
+			Group:   "analytics-group",
+			Topics:  []string{"game_start", "move", "game_end"},
+		},
+	}
+}
+
+// LoadConfig layers defaults, the optional YAML file, environment variables
+// and flags, in that order. The second return value reports --print-config.
+// With only three settings, flags are read once and applied at the end if
+// they were given, rather than parsed before and after the file.
+func LoadConfig(args []string) (*Config, bool, error) {
+	def := DefaultConfig()
+	fs := flag.NewFlagSet("analytics", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to the YAML config file shared with the game server")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	brokers := fs.String("kafka-brokers", strings.Join(def.Kafka.Brokers, ","), "comma separated Kafka brokers")
+	group := fs.String("kafka-group", def.Kafka.Group, "consumer group name")
+	topics := fs.String("kafka-topics", strings.Join(def.Kafka.Topics, ","), "comma separated topics to consume")
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+
+	cfg := def
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, false, err
+		}
+	}
+	cfg.loadEnv()
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "kafka-brokers":
+			cfg.Kafka.Brokers = splitList(*brokers)
+		case "kafka-group":
+			cfg.Kafka.Group = *group
+		case "kafka-topics":
+			cfg.Kafka.Topics = splitList(*topics)
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, false, err
+	}
+	return &cfg, *printConfig, nil
+}
+
+// loadFile reads the kafka section of the config file. The other sections
+// configure the game server and are ignored here.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	if err := yaml.NewDecoder(f).Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies the KAFKA_* variables the game server also reads; topics
+// are the consumer's own
+func (c *Config) loadEnv() {
+	if v := os.Getenv("KAFKA_BROKERS"); v != "" {
+		c.Kafka.Brokers = splitList(v)
+	}
+	if v := os.Getenv("KAFKA_GROUP"); v != "" {
+		c.Kafka.Group = v
+	}
+	if v := os.Getenv("KAFKA_TOPICS"); v != "" {
+		c.Kafka.Topics = splitList(v)
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	if len(c.Kafka.Brokers) == 0 {
+		errs = append(errs, errors.New("kafka.brokers must not be empty"))
+	}
+	for _, b := range c.Kafka.Brokers {
+		if !strings.Contains(b, ":") {
+			errs = append(errs, fmt.Errorf("kafka.brokers: %q is not host:port", b))
+		}
+	}
+	if c.Kafka.Group == "" {
+		errs = append(errs, errors.New("kafka.group must not be empty"))
+	}
+	if len(c.Kafka.Topics) == 0 {
+		errs = append(errs, errors.New("kafka.topics must not be empty"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// splitList reads a list setting such as "game_start, move", dropping
+// blank entries
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// mustLoadConfig handles --print-config and exits on invalid settings
+func mustLoadConfig(args []string) *Config {
+	cfg, printOnly, err := LoadConfig(args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printOnly {
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(cfg); err != nil {
+			log.Fatal(err)
+		}
+		enc.Close()
+		os.Exit(0)
+	}
+	return cfg
+}
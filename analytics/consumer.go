@@ -26,11 +26,10 @@ func (c *Consumer) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.C
 }
 
 func main() {
-	brokers := []string{"localhost:9092"}
-	group := "analytics-group"
+	cfg := mustLoadConfig(os.Args[1:])
 	config := sarama.NewConfig()
 	config.Version = sarama.V2_0_0_0
-	client, err := sarama.NewConsumerGroup(brokers, group, config)
+	client, err := sarama.NewConsumerGroup(cfg.Kafka.Brokers, cfg.Kafka.Group, config)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -38,7 +37,7 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
 		for {
-			if err := client.Consume(ctx, []string{"game_start", "move", "game_end"}, consumer); err != nil {
+			if err := client.Consume(ctx, cfg.Kafka.Topics, consumer); err != nil {
 				log.Println("error:", err)
 			}
 		}
@@ -2,6 +2,11 @@ module analytics
 
 go 1.25.3
 
+require (
+	github.com/Shopify/sarama v1.38.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/IBM/sarama v1.46.2 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -9,6 +14,8 @@ require (
 	github.com/eapache/go-xerial-snappy v0.0.0-20230731223053-c322873962e3 // indirect
 	github.com/eapache/queue v1.1.0 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.1.1 // indirect
 	github.com/hashicorp/go-uuid v1.0.3 // indirect
 	github.com/jcmturner/aescts/v2 v2.0.0 // indirect
 	github.com/jcmturner/dnsutils/v2 v2.0.0 // indirect
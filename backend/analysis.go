@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Move classifications
+const (
+	ClassBest       = "best"
+	ClassInaccuracy = "inaccuracy"
+	ClassMistake    = "mistake"
+	ClassBlunder    = "blunder"
+	ClassMissedWin  = "missed_win"
+)
+
+// How far below the best move's score, in engine points, a move has to fall
+// to count as an inaccuracy, a mistake or a blunder
+const (
+	inaccuracyLoss = 10
+	mistakeLoss    = 30
+	blunderLoss    = 80
+)
+
+// MoveAnalysis is the engine's verdict on one move. Evaluations are from
+// player 1's point of view, see WinScore for their scale.
+type MoveAnalysis struct {
+	Ply        int     `bson:"ply" json:"ply"` // 1-based
+	Player     string  `bson:"player" json:"player"`
+	Column     int     `bson:"column" json:"column"`           // 0-based
+	BestColumn int     `bson:"best_column" json:"best_column"` // 0-based
+	EvalBefore int     `bson:"eval_before" json:"eval_before"` // with the best move
+	EvalAfter  int     `bson:"eval_after" json:"eval_after"`   // with the move played
+	Class      string  `bson:"class" json:"class"`
+	Accuracy   float64 `bson:"accuracy" json:"accuracy"` // 0-100
+}
+
+// GameAnalysis is a finished game run through the engine, as cached in the
+// game_analysis collection
+type GameAnalysis struct {
+	GameID     string         `bson:"_id" json:"game_id"`
+	Engine     string         `bson:"engine" json:"engine"` // results are recomputed when this changes
+	Player1    string         `bson:"player1" json:"player1"`
+	Player2    string         `bson:"player2" json:"player2"`
+	Start      string         `bson:"start_position,omitempty" json:"start_position,omitempty"`
+	Moves      []MoveAnalysis `bson:"moves" json:"moves"`
+	Accuracy1  float64        `bson:"accuracy1" json:"player1_accuracy"`
+	Accuracy2  float64        `bson:"accuracy2" json:"player2_accuracy"`
+	AnalyzedAt time.Time      `bson:"analyzed_at" json:"analyzed_at"`
+}
+
+// engineName identifies the search settings an analysis was made with
+func engineName(depth int) string {
+	return fmt.Sprintf("negamax-d%d", depth)
+}
+
+// AnalyzeGame replays a stored game, scoring every legal move of every
+// position it went through. It gives up between moves once ctx is done.
+func AnalyzeGame(ctx context.Context, g GameDB, depth int) (*GameAnalysis, error) {
+	game := NewGame(g.GameID, g.Player1, g.Player2)
+	if g.Start != "" {
+		pos, err := ParsePosition(g.Start)
+		if err != nil {
+			return nil, fmt.Errorf("start position: %w", err)
+		}
+		game = NewGameFrom(g.GameID, g.Player1, g.Player2, pos)
+	}
+
+	a := &GameAnalysis{
+		GameID:  g.GameID,
+		Engine:  engineName(depth),
+		Player1: g.Player1,
+		Player2: g.Player2,
+		Start:   g.Start,
+		Moves:   []MoveAnalysis{},
+	}
+	var total, count [3]float64
+	for i, col := range g.MoveLog {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("stopped at move %d: %w", i+1, err)
+		}
+		pos := game.Position()
+		scores := ScoreMoves(pos, depth)
+		played, legal := scores[col]
+		best, bestScore, _ := BestMove(scores)
+		if !legal {
+			return nil, fmt.Errorf("move %d (column %d) is not legal", i+1, col+1)
+		}
+
+		sign := 1
+		if pos.Turn == P2 {
+			sign = -1
+		}
+		m := MoveAnalysis{
+			Ply:        i + 1,
+			Player:     game.CurrentPlayerName(),
+			Column:     col,
+			BestColumn: best,
+			EvalBefore: sign * bestScore,
+			EvalAfter:  sign * played,
+			Class:      classify(bestScore, played),
+			Accuracy:   moveAccuracy(bestScore, played),
+		}
+		a.Moves = append(a.Moves, m)
+		total[pos.Turn] += m.Accuracy
+		count[pos.Turn]++
+
+		if _, err := game.Drop(col, game.CurrentPlayerName()); err != nil {
+			return nil, fmt.Errorf("move %d (column %d): %w", i+1, col+1, err)
+		}
+	}
+	if count[P1] > 0 {
+		a.Accuracy1 = math.Round(10*total[P1]/count[P1]) / 10
+	}
+	if count[P2] > 0 {
+		a.Accuracy2 = math.Round(10*total[P2]/count[P2]) / 10
+	}
+	a.AnalyzedAt = time.Now()
+	return a, nil
+}
+
+// classify grades a move by the score it kept compared to the best move,
+// both from the mover's point of view
+func classify(best, played int) string {
+	switch {
+	case played >= best:
+		return ClassBest
+	case IsForcedWin(best) && !IsForcedWin(played):
+		return ClassMissedWin
+	case IsForcedWin(played), IsForcedLoss(best):
+		// Still winning, just more slowly, or lost whatever was played
+		return ClassBest
+	case IsForcedLoss(played):
+		return ClassBlunder
+	}
+	switch loss := best - played; {
+	case loss >= blunderLoss:
+		return ClassBlunder
+	case loss >= mistakeLoss:
+		return ClassMistake
+	case loss >= inaccuracyLoss:
+		return ClassInaccuracy
+	}
+	return ClassBest
+}
+
+// winChance maps a score to a rough chance of winning in percent
+func winChance(score int) float64 {
+	return 100 / (1 + math.Exp(-float64(score)/80))
+}
+
+// moveAccuracy turns the winning chances a move gave away into a 0-100
+// score, steeply at first so small slips still cost something
+func moveAccuracy(best, played int) float64 {
+	drop := winChance(best) - winChance(played)
+	acc := 103.1668*math.Exp(-0.04354*drop) - 3.1669
+	return math.Round(10*math.Max(0, math.Min(100, acc))) / 10
+}
+
+// Failed analyses are remembered so that polling clients get the error
+// instead of a fresh attempt on every request: games that cannot be replayed
+// for invalidAnalysisTTL, and analyses that ran out of time or hit a
+// database error for failedAnalysisTTL.
+const (
+	invalidAnalysisTTL = time.Hour
+	failedAnalysisTTL  = time.Minute
+)
+
+// AnalysisFailure is why the last analysis of a game failed
+type AnalysisFailure struct {
+	Err     error
+	Invalid bool // the stored game cannot be replayed; trying again will not help
+	Until   time.Time
+}
+
+// Analyzer runs game analyses on a fixed number of workers. Requests for a
+// game already queued or running are merged, and a full queue is reported
+// to the caller instead of piling up work.
+type Analyzer struct {
+	cfg     AnalysisConfig
+	db      *MongoDB
+	jobs    chan string
+	mu      sync.Mutex
+	pending map[string]bool             // queued or running
+	failed  map[string]*AnalysisFailure // recent failures, see Failure
+	log     *slog.Logger
+}
+
+func NewAnalyzer(cfg AnalysisConfig, db *MongoDB) *Analyzer {
+	return &Analyzer{
+		cfg:     cfg,
+		db:      db,
+		jobs:    make(chan string, cfg.Queue),
+		pending: make(map[string]bool),
+		failed:  make(map[string]*AnalysisFailure),
+		log:     slog.With("op", "analysis"),
+	}
+}
+
+// Failure returns the recent failure of gameID's analysis, if any
+func (a *Analyzer) Failure(gameID string) (*AnalysisFailure, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.failed[gameID]
+	if ok && !time.Now().Before(f.Until) {
+		delete(a.failed, gameID)
+		return nil, false
+	}
+	return f, ok
+}
+
+// fail records that gameID's analysis failed with err and forgets the
+// failures that have expired, so games never asked about again do not stay
+func (a *Analyzer) fail(gameID string, err error, invalid bool) {
+	ttl := failedAnalysisTTL
+	if invalid {
+		ttl = invalidAnalysisTTL
+	}
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, f := range a.failed {
+		if !now.Before(f.Until) {
+			delete(a.failed, id)
+		}
+	}
+	a.failed[gameID] = &AnalysisFailure{Err: err, Invalid: invalid, Until: now.Add(ttl)}
+}
+
+// Run starts the workers and returns once ctx is done
+func (a *Analyzer) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < a.cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case id := <-a.jobs:
+					a.analyze(ctx, id)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Enqueue schedules an analysis of gameID and reports false when the queue
+// is full
+func (a *Analyzer) Enqueue(gameID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending[gameID] {
+		return true
+	}
+	select {
+	case a.jobs <- gameID:
+		a.pending[gameID] = true
+		return true
+	default:
+		return false
+	}
+}
+
+// Engine names the settings this analyzer's results are cached under
+func (a *Analyzer) Engine() string {
+	return engineName(a.cfg.Depth)
+}
+
+func (a *Analyzer) analyze(ctx context.Context, gameID string) {
+	defer func() {
+		a.mu.Lock()
+		delete(a.pending, gameID)
+		a.mu.Unlock()
+	}()
+	log := a.log.With("game_id", gameID)
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var g GameDB
+	if err := a.db.Database.Collection("games").FindOne(ctx, bson.M{"game_id": gameID}).Decode(&g); err != nil {
+		log.Error("game lookup failed", "error", err)
+		a.fail(gameID, err, false)
+		return
+	}
+	start := time.Now()
+	res, err := AnalyzeGame(ctx, g, a.cfg.Depth)
+	if err != nil {
+		// Only running out of time is worth another try; anything else is
+		// a game that does not replay
+		log.Error("analysis failed", "error", err)
+		a.fail(gameID, err, ctx.Err() == nil)
+		return
+	}
+	_, err = a.db.Database.Collection("game_analysis").ReplaceOne(ctx, bson.M{"_id": gameID}, res,
+		options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Error("failed to store analysis", "error", err)
+		a.fail(gameID, err, false)
+		return
+	}
+	log.Info("game analyzed", "moves", len(res.Moves), "took", time.Since(start).String())
+}
+
+// cachedAnalysis returns the stored analysis of a game made with engine
+func (db *MongoDB) cachedAnalysis(ctx context.Context, gameID, engine string) (*GameAnalysis, error) {
+	var a GameAnalysis
+	err := db.Database.Collection("game_analysis").FindOne(ctx, bson.M{"_id": gameID, "engine": engine}).Decode(&a)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// registerAnalysisRoutes mounts GET /games/{id}/analysis. The first request
+// for a game queues the analysis and answers 202; clients poll until the
+// result is cached, or until a failure is reported as 422 for a game that
+// cannot be analyzed or 500 for one to retry later.
+func registerAnalysisRoutes(mux *http.ServeMux, db *MongoDB, analyzer *Analyzer) {
+	mux.HandleFunc("GET /games/{id}/analysis", withRequestLog("analysis", func(w http.ResponseWriter, r *http.Request) {
+		reqLog := requestLogger(r, "analysis")
+		id := r.PathValue("id")
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		cached, err := db.cachedAnalysis(ctx, id, analyzer.Engine())
+		if err == nil {
+			writeJSON(w, cached)
+			return
+		}
+		if err != mongo.ErrNoDocuments {
+			reqLog.Error("analysis lookup failed", "game_id", id, "error", err)
+			writeError(w, http.StatusInternalServerError, "lookup failed")
+			return
+		}
+
+		var g GameDB
+		err = db.Database.Collection("games").FindOne(ctx, bson.M{"game_id": id}).Decode(&g)
+		switch {
+		case err == mongo.ErrNoDocuments:
+			if archive, _ := db.archiveOf(ctx, id); archive != "" {
+				writeError(w, http.StatusGone, "game moved to archive "+archive)
+				return
+			}
+			writeError(w, http.StatusNotFound, "game not found")
+			return
+		case err != nil:
+			reqLog.Error("game lookup failed", "game_id", id, "error", err)
+			writeError(w, http.StatusInternalServerError, "lookup failed")
+			return
+		case !g.Finished:
+			writeError(w, http.StatusConflict, "game is still in progress")
+			return
+		case len(g.MoveLog) == 0:
+			writeError(w, http.StatusNotFound, "no moves recorded for this game")
+			return
+		}
+
+		if f, ok := analyzer.Failure(id); ok {
+			if f.Invalid {
+				writeError(w, http.StatusUnprocessableEntity, "game cannot be analyzed: "+f.Err.Error())
+				return
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(f.Until).Seconds()))))
+			writeError(w, http.StatusInternalServerError, "analysis failed, try again later")
+			return
+		}
+		if !analyzer.Enqueue(id) {
+			w.Header().Set("Retry-After", "10")
+			writeError(w, http.StatusServiceUnavailable, "analysis queue is full")
+			return
+		}
+		w.Header().Set("Retry-After", "2")
+		writeJSONStatus(w, http.StatusAccepted, map[string]string{"status": "pending"})
+	}))
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnalyzeGameStopsWhenCanceled(t *testing.T) {
+	g := GameDB{GameID: "g1", Player1: "alice", Player2: "bob", MoveLog: []int{3, 3, 2, 4}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AnalyzeGame(ctx, g, 4); !errors.Is(err, context.Canceled) {
+		t.Errorf("AnalyzeGame with a canceled context = %v, want context.Canceled", err)
+	}
+	a, err := AnalyzeGame(context.Background(), g, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Moves) != len(g.MoveLog) {
+		t.Errorf("analyzed %d moves, want %d", len(a.Moves), len(g.MoveLog))
+	}
+}
+
+func TestAnalyzeGameRejectsIllegalMoves(t *testing.T) {
+	g := GameDB{GameID: "g1", Player1: "alice", Player2: "bob", MoveLog: []int{0, 0, 0, 0, 0, 0, 0}}
+	if _, err := AnalyzeGame(context.Background(), g, 2); err == nil || !strings.Contains(err.Error(), "move 7") {
+		t.Errorf("AnalyzeGame of an overfilled column = %v, want an error at move 7", err)
+	}
+}
+
+func TestAnalyzerRemembersFailures(t *testing.T) {
+	a := NewAnalyzer(AnalysisConfig{Workers: 1, Queue: 1, Depth: 2}, nil)
+	if _, ok := a.Failure("g1"); ok {
+		t.Fatal("failure reported before any analysis")
+	}
+	a.fail("g1", errors.New("move 3 is not legal"), true)
+	a.fail("g2", context.DeadlineExceeded, false)
+
+	f, ok := a.Failure("g1")
+	if !ok || !f.Invalid || time.Until(f.Until) <= failedAnalysisTTL {
+		t.Errorf("g1 failure = %+v, want an invalid game remembered for about %s", f, invalidAnalysisTTL)
+	}
+	f, ok = a.Failure("g2")
+	if !ok || f.Invalid || time.Until(f.Until) > failedAnalysisTTL {
+		t.Errorf("g2 failure = %+v, want a retryable failure remembered for %s", f, failedAnalysisTTL)
+	}
+
+	f.Until = time.Now().Add(-time.Second)
+	if _, ok := a.Failure("g2"); ok {
+		t.Error("expired failure still reported")
+	}
+
+	// Expired failures go when another game fails, even if nobody asks
+	// about them again
+	f, _ = a.Failure("g1")
+	f.Until = time.Now().Add(-time.Second)
+	a.fail("g3", context.DeadlineExceeded, false)
+	if _, ok := a.failed["g1"]; ok || len(a.failed) != 1 {
+		t.Errorf("failures kept after g3 failed: %v, want only g3", a.failed)
+	}
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
+)
+
+// APIKeyPrefix starts every bot account's API key, which tells keys and
+// session tokens apart
+const APIKeyPrefix = "c4bot_"
+
+// GuestPrefix marks players who joined without an account. Their games are
+// stored but never rated.
+const GuestPrefix = "guest:"
+
+var (
+	errBadCredentials = errors.New("invalid username or password")
+	errUsernameTaken  = errors.New("username already taken")
+	errInvalidToken   = errors.New("invalid or expired token")
+	errGuestsDisabled = errors.New("guest play is disabled, please log in")
+	errBotNotFound    = errors.New("no such bot account of yours")
+)
+
+// Identity is who a request or socket acts as. UserID is empty for guests.
+// Bot accounts authenticate with an API key instead of a session token.
+type Identity struct {
+	UserID   string
+	Username string
+	Guest    bool
+	Bot      bool
+}
+
+// sessionClaims are carried in session tokens. The subject is the user ID.
+type sessionClaims struct {
+	Username string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+type identityKey struct{}
+
+// identityFrom returns the identity attached by the auth middleware
+func identityFrom(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// isGuestName reports whether a stored player name belongs to a guest
+func isGuestName(name string) bool {
+	return strings.HasPrefix(name, GuestPrefix)
+}
+
+// AuthService registers users, checks passwords and issues session tokens
+type AuthService struct {
+	cfg    AuthConfig
+	secret []byte
+	users  *mongo.Collection
+}
+
+func NewAuthService(cfg AuthConfig, db *MongoDB) *AuthService {
+	secret := []byte(cfg.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		rand.Read(secret)
+		slog.Warn("auth.secret not set, using a random key; tokens will not survive a restart", "op", "startup")
+	}
+
+	// Usernames are unique through the skeleton index created by migrations
+	return &AuthService{cfg: cfg, secret: secret, users: db.Database.Collection("users")}
+}
+
+// Register creates a new account and returns a session token for it.
+// Usernames are unique ignoring case and lookalike characters.
+func (a *AuthService) Register(ctx context.Context, username, password string) (string, error) {
+	username = norm.NFC.String(username)
+	if err := ValidateUsername(username); err != nil {
+		return "", err
+	}
+	if len(password) < 8 || len(password) > 72 {
+		return "", errors.New("password must be 8-72 characters")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	res, err := a.users.InsertOne(ctx, User{
+		Username:     username,
+		Skeleton:     usernameSkeleton(username),
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return "", errUsernameTaken
+	}
+	if err != nil {
+		return "", err
+	}
+	id := res.InsertedID.(primitive.ObjectID)
+	return a.issueToken(id.Hex(), username)
+}
+
+// Login checks the password and returns a fresh session token along with
+// the username as it was registered
+func (a *AuthService) Login(ctx context.Context, username, password string) (string, string, error) {
+	u, err := findUserByName(ctx, a.users, username)
+	if err == mongo.ErrNoDocuments {
+		return "", "", errBadCredentials
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return "", "", errBadCredentials
+	}
+	token, err := a.issueToken(u.ID.Hex(), u.Username)
+	return token, u.Username, err
+}
+
+// findUserByName looks up the account a name refers to: the one registered
+// under exactly that name, or else the one it looks like. The exact match
+// matters for the few accounts that kept an old skeleton because they clash
+// with another, see rebuildUsernameSkeletons.
+func findUserByName(ctx context.Context, users *mongo.Collection, username string) (User, error) {
+	cursor, err := users.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"username": username},
+		bson.M{"skeleton": usernameSkeleton(username)},
+	}}, options.Find().SetLimit(2))
+	if err != nil {
+		return User{}, err
+	}
+	var found []User
+	if err := cursor.All(ctx, &found); err != nil {
+		return User{}, err
+	}
+	if len(found) == 0 {
+		return User{}, mongo.ErrNoDocuments
+	}
+	for _, u := range found {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return found[0], nil
+}
+
+func (a *AuthService) issueToken(userID, username string) (string, error) {
+	now := time.Now()
+	claims := sessionClaims{
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(a.cfg.TokenTTL)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.secret)
+}
+
+// VerifyToken returns the identity a valid token was issued to
+func (a *AuthService) VerifyToken(token string) (Identity, error) {
+	var claims sessionClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return a.secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.Subject == "" || claims.Username == "" {
+		return Identity{}, errInvalidToken
+	}
+	return Identity{UserID: claims.Subject, Username: claims.Username}, nil
+}
+
+// CreateBot registers a bot account owned by ownerID and returns its API
+// key. Bot names follow the username policy and share its namespace.
+func (a *AuthService) CreateBot(ctx context.Context, ownerID, name string) (string, error) {
+	name = norm.NFC.String(name)
+	if err := ValidateUsername(name); err != nil {
+		return "", err
+	}
+	key := newAPIKey()
+	_, err := a.users.InsertOne(ctx, User{
+		Username:   name,
+		Skeleton:   usernameSkeleton(name),
+		Bot:        true,
+		OwnerID:    ownerID,
+		APIKeyHash: hashAPIKey(key),
+		CreatedAt:  time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return "", errUsernameTaken
+	}
+	if err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// RotateBotKey replaces the API key of ownerID's bot name and returns the
+// new one. The old key stops working at once.
+func (a *AuthService) RotateBotKey(ctx context.Context, ownerID, name string) (string, error) {
+	key := newAPIKey()
+	res, err := a.users.UpdateOne(ctx,
+		bson.M{"skeleton": usernameSkeleton(name), "bot": true, "owner_id": ownerID},
+		bson.M{"$set": bson.M{"api_key_hash": hashAPIKey(key)}},
+	)
+	if err != nil {
+		return "", err
+	}
+	if res.MatchedCount == 0 {
+		return "", errBotNotFound
+	}
+	return key, nil
+}
+
+// VerifyAPIKey returns the bot account a key belongs to
+func (a *AuthService) VerifyAPIKey(ctx context.Context, key string) (Identity, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var u User
+	err := a.users.FindOne(ctx, bson.M{"api_key_hash": hashAPIKey(key), "bot": true}).Decode(&u)
+	if err != nil {
+		return Identity{}, errInvalidToken
+	}
+	return Identity{UserID: u.ID.Hex(), Username: u.Username, Bot: true}, nil
+}
+
+func newAPIKey() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return APIKeyPrefix + hex.EncodeToString(b)
+}
+
+// hashAPIKey is how keys are stored. Keys are random, so a plain hash is
+// enough and lets a key be looked up directly.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenFrom reads the bearer token from the Authorization header, or from
+// the token query parameter since browsers cannot set headers on WebSockets
+func tokenFrom(r *http.Request) string {
+	if t, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return t
+	}
+	return r.URL.Query().Get("token")
+}
+
+// OptionalAuth attaches the caller's identity when a token is present and
+// rejects invalid tokens. Requests without one continue as guests when
+// guests are allowed.
+func (a *AuthService) OptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFrom(r)
+		if token == "" {
+			if !a.cfg.AllowGuests {
+				writeError(w, http.StatusUnauthorized, errGuestsDisabled.Error())
+				return
+			}
+			next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, Identity{Guest: true})))
+			return
+		}
+		verify := a.VerifyToken
+		if strings.HasPrefix(token, APIKeyPrefix) {
+			verify = func(key string) (Identity, error) { return a.VerifyAPIKey(r.Context(), key) }
+		}
+		id, err := verify(token)
+		if err != nil {
+			requestLogger(r, "auth").Warn("rejected token", "path", r.URL.Path)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	}
+}
+
+// RequireAuth only lets registered users through. Every REST endpoint that
+// changes data is wrapped in it. Bot accounts have no session tokens, so
+// they only ever get as far as the WebSocket.
+func (a *AuthService) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := a.VerifyToken(tokenFrom(r))
+		if err != nil {
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	}
+}
+
+// guestName namespaces the name a guest asked for, or invents one. The
+// requested name must pass the same policy as registered usernames.
+func guestName(requested string) (string, error) {
+	requested = norm.NFC.String(strings.TrimSpace(requested))
+	if requested == "" {
+		b := make([]byte, 3)
+		rand.Read(b)
+		requested = "player-" + hex.EncodeToString(b)
+	}
+	if err := ValidateUsername(requested); err != nil {
+		return "", err
+	}
+	return GuestPrefix + requested, nil
+}
+
+// registerAuthRoutes mounts /auth/register, /auth/login and /auth/me, and
+// POST /bots and POST /bots/{name}/key for registering bot accounts and
+// replacing their keys
+func registerAuthRoutes(mux *http.ServeMux, auth *AuthService) {
+	type credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	type tokenResponse struct {
+		Username string `json:"username"`
+		Token    string `json:"token"`
+	}
+
+	readCredentials := func(w http.ResponseWriter, r *http.Request) (credentials, bool) {
+		var c credentials
+		if r.Method == http.MethodOptions {
+			setCORSHeaders(w)
+			w.WriteHeader(http.StatusNoContent)
+			return c, false
+		}
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return c, false
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&c); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return c, false
+		}
+		return c, true
+	}
+
+	mux.HandleFunc("/auth/register", withRequestLog("register", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := readCredentials(w, r)
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		token, err := auth.Register(ctx, c.Username, c.Password)
+		switch {
+		case err == errUsernameTaken:
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			requestLogger(r, "register").Info("registration rejected", "username", c.Username, "error", err)
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			requestLogger(r, "register").Info("user registered", "username", c.Username)
+			writeJSON(w, tokenResponse{Username: c.Username, Token: token})
+		}
+	}))
+
+	mux.HandleFunc("/auth/login", withRequestLog("login", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := readCredentials(w, r)
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		token, username, err := auth.Login(ctx, c.Username, c.Password)
+		switch {
+		case err == errBadCredentials:
+			requestLogger(r, "login").Info("login failed", "username", c.Username)
+			writeError(w, http.StatusUnauthorized, err.Error())
+		case err != nil:
+			requestLogger(r, "login").Error("login error", "username", c.Username, "error", err)
+			writeError(w, http.StatusInternalServerError, "login failed")
+		default:
+			writeJSON(w, tokenResponse{Username: username, Token: token})
+		}
+	}))
+
+	mux.HandleFunc("/auth/me", withRequestLog("me", auth.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := identityFrom(r.Context())
+		writeJSON(w, map[string]string{"id": id.UserID, "username": id.Username})
+	})))
+
+	type botKeyResponse struct {
+		Username string `json:"username"`
+		APIKey   string `json:"api_key"`
+	}
+
+	mux.HandleFunc("POST /bots", withRequestLog("create_bot", auth.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+		owner, _ := identityFrom(r.Context())
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		key, err := auth.CreateBot(ctx, owner.UserID, body.Name)
+		switch {
+		case err == errUsernameTaken:
+			writeError(w, http.StatusConflict, err.Error())
+		case err != nil:
+			requestLogger(r, "create_bot").Info("bot registration rejected", "name", body.Name, "error", err)
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			requestLogger(r, "create_bot").Info("bot account registered", "name", body.Name, "owner", owner.Username)
+			writeJSON(w, botKeyResponse{Username: body.Name, APIKey: key})
+		}
+	})))
+
+	mux.HandleFunc("POST /bots/{name}/key", withRequestLog("rotate_bot_key", auth.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		owner, _ := identityFrom(r.Context())
+		name := r.PathValue("name")
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		key, err := auth.RotateBotKey(ctx, owner.UserID, name)
+		switch {
+		case err == errBotNotFound:
+			writeError(w, http.StatusNotFound, err.Error())
+		case err != nil:
+			requestLogger(r, "rotate_bot_key").Error("could not rotate bot key", "name", name, "error", err)
+			writeError(w, http.StatusInternalServerError, "could not rotate key")
+		default:
+			requestLogger(r, "rotate_bot_key").Info("bot key rotated", "name", name)
+			writeJSON(w, botKeyResponse{Username: name, APIKey: key})
+		}
+	})))
+}
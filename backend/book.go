@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Opening books are stored as the magic "C4OB", a version byte, the number
+// of plies covered as a byte and the entry count as a uint32, followed by the
+// entries sorted by key. Each entry is the position key (player 1's and
+// player 2's discs as two uint64 bitboards, see cellBit), a move count byte
+// and, per move, the column as a byte and its score as an int16. Integers
+// are little endian. Scores are on the engine's scale from the point of view
+// of the side to move.
+const (
+	bookMagic   = "C4OB"
+	bookVersion = 1
+)
+
+// Book moves scoring further than bookMargin below the best are never
+// played; the rest are weighted by how close they come, with the weight
+// falling by e for every bookTemperature points.
+const (
+	bookMargin      = 12
+	bookTemperature = 4
+)
+
+// BookMove is a move of a book position with its score
+type BookMove struct {
+	Column int
+	Score  int
+}
+
+// OpeningBook maps positions in the first Plies plies to scored moves
+type OpeningBook struct {
+	Plies   int
+	entries map[ttKey][]BookMove
+}
+
+func NewOpeningBook(plies int) *OpeningBook {
+	return &OpeningBook{Plies: plies, entries: make(map[ttKey][]BookMove)}
+}
+
+// Len returns the number of positions in the book
+func (b *OpeningBook) Len() int { return len(b.entries) }
+
+// Lookup returns the book moves of pos
+func (b *OpeningBook) Lookup(pos Position) ([]BookMove, bool) {
+	moves, ok := b.entries[newSearchBoard(pos).key]
+	return moves, ok
+}
+
+// Add sets the moves of pos, replacing any already there
+func (b *OpeningBook) Add(pos Position, moves []BookMove) {
+	b.entries[newSearchBoard(pos).key] = moves
+}
+
+// Choose picks a move for pos at random among the good ones, favoring the
+// best, so the bot's openings vary. ok is false when pos is not in the book.
+func (b *OpeningBook) Choose(pos Position, rng *rand.Rand) (col int, ok bool) {
+	moves, found := b.Lookup(pos)
+	if !found || len(moves) == 0 {
+		return 0, false
+	}
+	best := moves[0].Score
+	for _, m := range moves {
+		best = max(best, m.Score)
+	}
+	var cols []int
+	var weights []float64
+	total := 0.0
+	for _, m := range moves {
+		if best-m.Score > bookMargin {
+			continue
+		}
+		w := math.Exp(-float64(best-m.Score) / bookTemperature)
+		cols = append(cols, m.Column)
+		weights = append(weights, w)
+		total += w
+	}
+	pick := rng.Float64() * total
+	for i, w := range weights {
+		if pick < w {
+			return cols[i], true
+		}
+		pick -= w
+	}
+	return cols[len(cols)-1], true
+}
+
+// WriteTo writes the book in its file format
+func (b *OpeningBook) WriteTo(w io.Writer) (int64, error) {
+	keys := make([]ttKey, 0, len(b.entries))
+	for k := range b.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+
+	buf := []byte(bookMagic)
+	buf = append(buf, bookVersion, byte(b.Plies))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(keys)))
+	for _, k := range keys {
+		moves := b.entries[k]
+		buf = binary.LittleEndian.AppendUint64(buf, k[0])
+		buf = binary.LittleEndian.AppendUint64(buf, k[1])
+		buf = append(buf, byte(len(moves)))
+		for _, m := range moves {
+			score := max(math.MinInt16, min(math.MaxInt16, m.Score))
+			buf = append(buf, byte(m.Column))
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(int16(score)))
+		}
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// ReadBook reads a book written by WriteTo
+func ReadBook(r io.Reader) (*OpeningBook, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(bookMagic)+6)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	if string(header[:len(bookMagic)]) != bookMagic {
+		return nil, errors.New("not an opening book")
+	}
+	if v := header[len(bookMagic)]; v != bookVersion {
+		return nil, fmt.Errorf("unsupported book version %d", v)
+	}
+	b := NewOpeningBook(int(header[len(bookMagic)+1]))
+	count := binary.LittleEndian.Uint32(header[len(bookMagic)+2:])
+
+	entry := make([]byte, 17)
+	for i := uint32(0); i < count; i++ {
+		if _, err := io.ReadFull(br, entry); err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		k := ttKey{binary.LittleEndian.Uint64(entry), binary.LittleEndian.Uint64(entry[8:])}
+		moves := make([]BookMove, entry[16])
+		for j := range moves {
+			var m [3]byte
+			if _, err := io.ReadFull(br, m[:]); err != nil {
+				return nil, fmt.Errorf("entry %d: %w", i, err)
+			}
+			if m[0] >= Cols {
+				return nil, fmt.Errorf("entry %d: column %d out of range", i, m[0])
+			}
+			moves[j] = BookMove{Column: int(m[0]), Score: int(int16(binary.LittleEndian.Uint16(m[1:])))}
+		}
+		b.entries[k] = moves
+	}
+	return b, nil
+}
+
+// LoadBook reads a book file
+func LoadBook(path string) (*OpeningBook, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ReadBook(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return b, nil
+}
+
+// SaveBook writes a book file, replacing it only once it is complete
+func SaveBook(path string, b *OpeningBook) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := b.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// GenerateBook searches every position reachable in the first plies plies
+// through moves that score within margin of the best, each to depth plies.
+// Each ply's positions are searched by workers goroutines at once.
+// progress, if set, is called with the number of positions done so far.
+func GenerateBook(plies, depth, margin, workers int, progress func(int)) *OpeningBook {
+	b := NewOpeningBook(plies)
+	start, _ := ParsePosition(StartPosition)
+	level := []Position{start}
+	for ply := 0; ply < plies && len(level) > 0; ply++ {
+		scored := make([][]BookMove, len(level))
+		jobs := make(chan int)
+		var done atomic.Int64
+		var wg sync.WaitGroup
+		for w := 0; w < max(1, workers); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					scores := scoreMovesTT(level[i], depth)
+					_, best, _ := BestMove(scores)
+					for _, c := range searchOrder {
+						if s, ok := scores[c]; ok && best-s <= margin {
+							scored[i] = append(scored[i], BookMove{Column: c, Score: s})
+						}
+					}
+					n := done.Add(1)
+					if progress != nil {
+						progress(b.Len() + int(n))
+					}
+				}
+			}()
+		}
+		for i := range level {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+
+		next := map[ttKey]Position{}
+		for i, pos := range level {
+			b.Add(pos, scored[i])
+			for _, m := range scored[i] {
+				g := NewGameFrom("", "x", "o", pos)
+				g.Drop(m.Column, g.CurrentPlayerName())
+				if after := g.Position(); !after.Finished {
+					next[newSearchBoard(after).key] = after
+				}
+			}
+		}
+		level = level[:0]
+		for _, pos := range next {
+			level = append(level, pos)
+		}
+	}
+	return b
+}
+
+// scoreMovesTT is ScoreMoves sharing a transposition table between the
+// moves, which pays off at the depths a book is built with
+func scoreMovesTT(pos Position, depth int) map[int]int {
+	scores := map[int]int{}
+	b := newSearchBoard(pos)
+	b.tt = make(map[ttKey]ttEntry)
+	for _, c := range searchOrder {
+		if !b.canPlay(c) {
+			continue
+		}
+		if b.wins(c) {
+			scores[c] = WinScore - 1
+			continue
+		}
+		b.play(c)
+		scores[c] = -b.negamax(depth-1, -WinScore, WinScore, 1)
+		b.undo(c)
+	}
+	return scores
+}
+
+// BookFromGames builds a book from how the moves in the first plies plies
+// of stored games worked out, keeping moves played in at least minGames
+// games. A move's score is its results turned into the engine's scale.
+func (db *MongoDB) BookFromGames(ctx context.Context, plies, minGames int) (*OpeningBook, int, error) {
+	cursor, err := db.Database.Collection("games").Find(ctx, bson.M{
+		"finished":       true,
+		"aborted":        bson.M{"$ne": true},
+		"start_position": bson.M{"$exists": false},
+		"outcome":        bson.M{"$in": bson.A{ResultP1Win, ResultP2Win, ResultDraw}},
+		"move_log.0":     bson.M{"$exists": true},
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	type tally struct{ games, points float64 }
+	stats := map[ttKey]map[int]*tally{}
+	positions := map[ttKey]Position{}
+	games := 0
+	for cursor.Next(ctx) {
+		var g GameDB
+		if err := cursor.Decode(&g); err != nil {
+			return nil, games, err
+		}
+		games++
+		game := NewGame(g.GameID, "x", "o")
+		for i, col := range g.MoveLog {
+			if i >= plies {
+				break
+			}
+			pos := game.Position()
+			key := newSearchBoard(pos).key
+			if stats[key] == nil {
+				stats[key] = map[int]*tally{}
+				positions[key] = pos
+			}
+			t := stats[key][col]
+			if t == nil {
+				t = &tally{}
+				stats[key][col] = t
+			}
+			t.games++
+			switch g.Outcome {
+			case winFor(pos.Turn):
+				t.points++
+			case ResultDraw:
+				t.points += 0.5
+			}
+			if _, err := game.Drop(col, game.CurrentPlayerName()); err != nil {
+				break
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, games, err
+	}
+
+	b := NewOpeningBook(plies)
+	for key, cols := range stats {
+		var moves []BookMove
+		for _, c := range searchOrder {
+			t := cols[c]
+			if t == nil || t.games < float64(minGames) {
+				continue
+			}
+			moves = append(moves, BookMove{Column: c, Score: scoreFromResults(t.points / t.games)})
+		}
+		if len(moves) > 0 {
+			b.Add(positions[key], moves)
+		}
+	}
+	return b, games, nil
+}
+
+// scoreFromResults turns a share of points into engine points, inverting
+// winChance and capping it well short of a forced result
+func scoreFromResults(p float64) int {
+	p = max(0.01, min(0.99, p))
+	return int(math.Round(80 * math.Log(p/(1-p))))
+}
+
+// bookEngine plays from an opening book for the first plies of a game and
+// leaves the rest to the engine it wraps
+type bookEngine struct {
+	BotEngine
+	book  *OpeningBook
+	plies int
+	rng   *rand.Rand
+}
+
+// WithOpeningBook wraps e to consult book while fewer than plies discs are
+// on the board. The name is e's, so results stay filed under the engine.
+func WithOpeningBook(e BotEngine, book *OpeningBook, plies int, rng *rand.Rand) BotEngine {
+	if book == nil || plies == 0 {
+		return e
+	}
+	return &bookEngine{BotEngine: e, book: book, plies: min(plies, book.Plies), rng: rng}
+}
+
+func (e *bookEngine) ChooseMove(pos Position, budget time.Duration) int {
+	if newSearchBoard(pos).discs < e.plies {
+		if col, ok := e.book.Choose(pos, e.rng); ok {
+			return col
+		}
+	}
+	return e.BotEngine.ChooseMove(pos, budget)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"math/rand/v2"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBookRoundTrip(t *testing.T) {
+	start := mustParse(t, StartPosition)
+	after := mustParse(t, "7/7/7/7/7/3x3 o classic")
+	b := NewOpeningBook(2)
+	b.Add(start, []BookMove{{3, 10}, {2, -5}, {4, 40000}, {0, -40000}})
+	b.Add(after, []BookMove{{3, -7}})
+
+	var buf bytes.Buffer
+	n, err := b.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo reported %d bytes, wrote %d", n, buf.Len())
+	}
+	got, err := ReadBook(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Plies != 2 || got.Len() != 2 {
+		t.Errorf("read %d plies and %d positions, want 2 and 2", got.Plies, got.Len())
+	}
+	want := map[string][]BookMove{
+		StartPosition:  {{3, 10}, {2, -5}, {4, math.MaxInt16}, {0, math.MinInt16}},
+		after.String(): {{3, -7}},
+	}
+	for s, moves := range want {
+		if m, ok := got.Lookup(mustParse(t, s)); !ok || !reflect.DeepEqual(m, moves) {
+			t.Errorf("moves of %s = %v, want %v", s, m, moves)
+		}
+	}
+}
+
+func TestReadBookRejectsBadFiles(t *testing.T) {
+	b := NewOpeningBook(1)
+	b.Add(mustParse(t, StartPosition), []BookMove{{3, 10}, {2, 4}})
+	var buf bytes.Buffer
+	if _, err := b.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	good := buf.Bytes()
+
+	edit := func(i int, v byte) []byte {
+		data := bytes.Clone(good)
+		data[i] = v
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"bad magic", edit(0, 'X'), "not an opening book"},
+		{"bad version", edit(len(bookMagic), bookVersion+1), "unsupported book version"},
+		{"column out of range", edit(len(good)-3, Cols), "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadBook(bytes.NewReader(tt.data)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ReadBook = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+	for n := 0; n < len(good); n++ {
+		if _, err := ReadBook(bytes.NewReader(good[:n])); err == nil {
+			t.Errorf("ReadBook of the first %d of %d bytes succeeded", n, len(good))
+		}
+	}
+}
+
+func TestChooseStaysWithinMargin(t *testing.T) {
+	start := mustParse(t, StartPosition)
+	b := NewOpeningBook(1)
+	b.Add(start, []BookMove{{2, 20 - bookMargin}, {3, 20}, {4, 20 - bookMargin - 1}, {0, -50}})
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	picks := map[int]int{}
+	for i := 0; i < 2000; i++ {
+		col, ok := b.Choose(start, rng)
+		if !ok {
+			t.Fatal("start position not found in the book")
+		}
+		picks[col]++
+	}
+	if picks[4] > 0 || picks[0] > 0 {
+		t.Errorf("picked moves more than %d below the best: %v", bookMargin, picks)
+	}
+	if picks[2] == 0 || picks[3] <= picks[2] {
+		t.Errorf("picks = %v, want the best most often and the move at the margin sometimes", picks)
+	}
+	if _, ok := b.Choose(mustParse(t, "7/7/7/7/7/x6 o classic"), rng); ok {
+		t.Error("Choose found a move for a position outside the book")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BotEngine chooses moves for a bot player. ChooseMove is only called on
+// positions that are not finished and should return within budget; it runs
+// outside the hub lock. An engine value plays a single game, so it may keep
+// state between moves.
+type BotEngine interface {
+	Name() string
+	ChooseMove(pos Position, budget time.Duration) int
+}
+
+// BotPrefix starts the player name of every bot, followed by its engine.
+// Usernames cannot contain ':', so these never clash with people.
+const BotPrefix = "bot:"
+
+// BotName is the player name of the built-in bot and BotID its player ID.
+// Neither can be taken by a person since "bot" is a reserved username.
+// Games from before engines were pluggable were played by this bot, which
+// was the heuristic engine; newer games use BotPlayerName.
+const (
+	BotName = "BOT"
+	BotID   = "bot"
+)
+
+// BotPlayerName returns the player name, which doubles as the player ID, of
+// the bot playing engine
+func BotPlayerName(engine string) string {
+	return BotPrefix + engine
+}
+
+// isBotName reports whether a stored player name belongs to a bot
+func isBotName(name string) bool {
+	return name == BotName || strings.HasPrefix(name, BotPrefix)
+}
+
+// botEngineOf returns the engine name of a bot player name
+func botEngineOf(name string) string {
+	if name == BotName {
+		return "heuristic"
+	}
+	return strings.TrimPrefix(name, BotPrefix)
+}
+
+// botEngines holds a constructor for every engine by name. Engines draw
+// whatever randomness they need from rng.
+var botEngines = map[string]func(rng *rand.Rand) BotEngine{
+	"heuristic":      func(*rand.Rand) BotEngine { return heuristicEngine{} },
+	"random":         func(rng *rand.Rand) BotEngine { return randomEngine{rng: rng} },
+	"minimax-easy":   func(*rand.Rand) BotEngine { return minimaxEngine{name: "minimax-easy", depth: 2} },
+	"minimax-medium": func(*rand.Rand) BotEngine { return minimaxEngine{name: "minimax-medium", depth: 5} },
+	"minimax-hard":   func(*rand.Rand) BotEngine { return minimaxEngine{name: "minimax-hard", depth: 10} },
+	"solver":         func(*rand.Rand) BotEngine { return minimaxEngine{name: "solver", depth: maxPlies, tt: true} },
+	"mcts-easy": func(rng *rand.Rand) BotEngine {
+		return NewMCTSEngine("mcts-easy", MCTSConfig{Iterations: 300, Seed: rng.Uint64()})
+	},
+	"mcts-medium": func(rng *rand.Rand) BotEngine {
+		return NewMCTSEngine("mcts-medium", MCTSConfig{Iterations: 3000, Heavy: true, Seed: rng.Uint64()})
+	},
+	"mcts-hard": func(rng *rand.Rand) BotEngine {
+		return NewMCTSEngine("mcts-hard", MCTSConfig{Workers: 4, Heavy: true, Seed: rng.Uint64()})
+	},
+}
+
+// NewBotEngine returns a fresh engine by name
+func NewBotEngine(name string) (BotEngine, bool) {
+	return NewSeededBotEngine(name, rand.Uint64())
+}
+
+// NewSeededBotEngine returns a fresh engine by name whose random choices all
+// follow from seed. Engines limited by time rather than by depth or
+// iterations still vary with how far they get within the budget.
+func NewSeededBotEngine(name string, seed uint64) (BotEngine, bool) {
+	newEngine, ok := botEngines[name]
+	if !ok {
+		return nil, false
+	}
+	return newEngine(rand.New(rand.NewPCG(seed, 0))), true
+}
+
+// BotEngineNames lists the registered engines in order
+func BotEngineNames() []string {
+	names := make([]string, 0, len(botEngines))
+	for name := range botEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// heuristicEngine is the original bot: win, block, else prefer the center
+type heuristicEngine struct{}
+
+func (heuristicEngine) Name() string { return "heuristic" }
+
+func (heuristicEngine) ChooseMove(pos Position, _ time.Duration) int {
+	g := &GameLogic{Board: pos.Board, Turn: pos.Turn, Player1: "x", Player2: "o"}
+	return g.BotChooseColumn(g.CurrentPlayerName())
+}
+
+// randomEngine plays any legal column
+type randomEngine struct {
+	rng *rand.Rand
+}
+
+func (randomEngine) Name() string { return "random" }
+
+func (e randomEngine) ChooseMove(pos Position, _ time.Duration) int {
+	var legal []int
+	for c := 0; c < Cols; c++ {
+		if pos.Board[0][c] == Empty {
+			legal = append(legal, c)
+		}
+	}
+	return legal[e.rng.IntN(len(legal))]
+}
+
+// minimaxEngine searches ever deeper until depth or the budget runs out.
+// With tt and an unbounded depth it becomes a solver that plays perfectly
+// whenever the budget lets it see to the end of the game.
+type minimaxEngine struct {
+	name  string
+	depth int
+	tt    bool
+	style Style
+}
+
+func (e minimaxEngine) Name() string { return e.name }
+
+func (e minimaxEngine) ChooseMove(pos Position, budget time.Duration) int {
+	col, _, _, _ := searchBest(pos, e.depth, time.Now().Add(budget), e.tt, e.style)
+	return col
+}
+
+func (e minimaxEngine) WithStyle(s Style) BotEngine {
+	e.style = s
+	return e
+}
+
+// styledEngine is implemented by engines whose evaluation can take a
+// Style; the others play the same whatever the personality
+type styledEngine interface {
+	WithStyle(s Style) BotEngine
+}
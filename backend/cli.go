@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// commands are maintenance tasks run as `backend <command> [flags] [args]`.
+// They take the same configuration flags, file and environment as the
+// server; args are whatever follows the flags.
+var commands = map[string]func(cfg *Config, args []string) error{
+	"backfill-stats": cmdBackfillStats,
+	"migrate":        cmdMigrate,
+	"retention":      cmdRetention,
+	"export":         cmdExport,
+	"import":         cmdImport,
+	"book":           cmdBook,
+	"tournament":     cmdTournament,
+	"engine":         cmdEngine,
+}
+
+func runCommand(name string, cmd func(*Config, []string) error, args []string) {
+	cfg, rest := mustLoadConfig(args)
+	slog.SetDefault(NewLogger(cfg.Log, os.Stderr))
+	RegisterExternalEngines(cfg.Game.ExternalEngines)
+	defer CloseExternalEngines()
+	if err := cmd(cfg, rest); err != nil {
+		slog.Error("command failed", "op", name, "error", err)
+		os.Exit(1)
+	}
+}
+
+// cmdBackfillStats rebuilds the /stats counters and player profiles from
+// the stored game results
+func cmdBackfillStats(cfg *Config, _ []string) error {
+	db := InitDB(cfg.Mongo, nopMetrics{})
+	defer db.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	return db.RebuildStats(ctx)
+}
+
+// cmdMigrate applies pending schema migrations and lists every migration
+// with the time it was applied
+func cmdMigrate(cfg *Config, _ []string) error {
+	db := InitDB(cfg.Mongo, nopMetrics{})
+	defer db.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	if err := db.Migrate(ctx); err != nil {
+		return err
+	}
+	applied, err := db.AppliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		fmt.Printf("%3d  %-28s  %s\n", m.Version, m.Name, applied[m.Version].AppliedAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// migrateOnStart applies pending migrations when the server starts, or only
+// warns about them when auto_migrate is off
+func migrateOnStart(cfg MongoConfig, db *MongoDB) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if !cfg.AutoMigrate {
+		pending, err := db.PendingMigrations(ctx)
+		if err != nil {
+			slog.Error("failed to read schema_migrations", "op", "startup", "error", err)
+			return
+		}
+		if len(pending) > 0 {
+			slog.Warn("database has pending migrations, run the migrate command", "op", "startup", "pending", len(pending))
+		}
+		return
+	}
+	if err := db.Migrate(ctx); err != nil {
+		slog.Error("migration failed", "op", "startup", "error", err)
+		os.Exit(1)
+	}
+}
+
+// cmdRetention runs the retention policies once. Combine it with
+// -retention-dry-run to see what a run would change.
+func cmdRetention(cfg *Config, _ []string) error {
+	db := InitDB(cfg.Mongo, nopMetrics{})
+	defer db.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	rep, err := NewRetentionJob(cfg.Retention, db, nil, nil).RunOnce(ctx)
+	if err != nil {
+		return err
+	}
+	verb := "aborted %d games, archived %d"
+	if cfg.Retention.DryRun {
+		verb = "would abort %d games and archive %d"
+	}
+	fmt.Printf(verb+"\n", rep.Aborted, rep.Archived)
+	if rep.File != "" {
+		fmt.Println("archive:", rep.File)
+	}
+	return nil
+}
+
+// cmdExport writes games in portable notation to stdout:
+//
+//	export game <game-id>...
+//	export player <username>
+func cmdExport(cfg *Config, args []string) error {
+	if len(args) < 2 || (args[0] != "game" && args[0] != "player") {
+		return errors.New("usage: export [flags] game <game-id>... | player <username>")
+	}
+	db := InitDB(cfg.Mongo, nopMetrics{})
+	defer db.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	if args[0] == "player" {
+		st, err := db.playerByName(ctx, args[1])
+		if err != nil {
+			return fmt.Errorf("player %s: %w", args[1], err)
+		}
+		n, err := db.ExportPlayerGames(ctx, os.Stdout, st.PlayerID)
+		slog.Info("exported games", "op", "export", "player", st.Username, "games", n)
+		return err
+	}
+	for _, id := range args[1:] {
+		rec, err := db.GameRecord(ctx, id)
+		if err != nil {
+			return fmt.Errorf("game %s: %w", id, err)
+		}
+		if err := WriteGame(os.Stdout, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cmdImport reads games in portable notation from files ("-" for stdin).
+// Every game is checked before any is stored, so a bad file imports nothing.
+func cmdImport(cfg *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: import [flags] <file>...")
+	}
+	var records []GameRecord
+	var errs []error
+	for _, path := range args {
+		recs, err := readGameFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		for i, rec := range recs {
+			if _, err := rec.Rebuild(); err != nil {
+				errs = append(errs, fmt.Errorf("%s: game %d: %w", path, i+1, err))
+			}
+		}
+		records = append(records, recs...)
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	db := InitDB(cfg.Mongo, nopMetrics{})
+	defer db.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	for _, rec := range records {
+		id, err := db.ImportGame(ctx, rec)
+		if err != nil {
+			return err
+		}
+		fmt.Println(id)
+	}
+	slog.Info("imported games", "op", "import", "games", len(records))
+	return nil
+}
+
+func readGameFile(path string) ([]GameRecord, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return ReadGames(r)
+}
+
+// cmdBook builds and inspects opening books:
+//
+//	book generate [-plies n] [-depth d] [-margin m] [-workers w] <file>
+//	book games [-plies n] [-min-games k] <file>
+//	book show <file> [position]
+//
+// generate searches the opening with the engine; games learns it from the
+// stored games instead.
+func cmdBook(cfg *Config, args []string) error {
+	usage := errors.New("usage: book generate|games [-plies n] ... <file> | book show <file> [position]")
+	if len(args) < 2 {
+		return usage
+	}
+	if args[0] == "show" {
+		return showBook(args[1], args[2:])
+	}
+
+	fs := flag.NewFlagSet("book "+args[0], flag.ContinueOnError)
+	plies := fs.Int("plies", 8, "plies covered by the book")
+	depth := fs.Int("depth", 12, "generate: plies searched for each position")
+	margin := fs.Int("margin", 4, "generate: follow moves scoring within this of the best")
+	workers := fs.Int("workers", runtime.NumCPU(), "generate: positions searched at once")
+	minGames := fs.Int("min-games", 10, "games: keep moves played in at least this many games")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usage
+	}
+	if *plies < 1 || *plies > maxPlies {
+		return fmt.Errorf("-plies must be between 1 and %d", maxPlies)
+	}
+	out := fs.Arg(0)
+
+	var book *OpeningBook
+	switch args[0] {
+	case "generate":
+		if *depth < 1 {
+			return errors.New("-depth must be positive")
+		}
+		started := time.Now()
+		book = GenerateBook(*plies, *depth, *margin, *workers, func(n int) {
+			if n%100 == 0 {
+				slog.Info("generating opening book", "op", "book", "positions", n, "took", time.Since(started).Round(time.Second).String())
+			}
+		})
+	case "games":
+		db := InitDB(cfg.Mongo, nopMetrics{})
+		defer db.Disconnect()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+		var games int
+		var err error
+		if book, games, err = db.BookFromGames(ctx, *plies, *minGames); err != nil {
+			return err
+		}
+		slog.Info("read stored games", "op", "book", "games", games)
+	default:
+		return usage
+	}
+	if err := SaveBook(out, book); err != nil {
+		return err
+	}
+	slog.Info("wrote opening book", "op", "book", "file", out, "positions", book.Len(), "plies", book.Plies)
+	return nil
+}
+
+// showBook prints a book's size, and the moves of position if given
+func showBook(path string, args []string) error {
+	book, err := LoadBook(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d positions, %d plies\n", book.Len(), book.Plies)
+	if len(args) == 0 {
+		return nil
+	}
+	pos, err := ParsePosition(args[0])
+	if err != nil {
+		return err
+	}
+	moves, ok := book.Lookup(pos)
+	if !ok {
+		fmt.Println("position not in book")
+		return nil
+	}
+	for _, m := range moves {
+		fmt.Printf("column %d  score %d\n", m.Column+1, m.Score)
+	}
+	return nil
+}
+
+// cmdTournament plays bots against each other and prints the results:
+//
+//	tournament round-robin|gauntlet [-games n] [-workers w] [-seed s]
+//	           [-budget d] [-openings file] [-book file] <engine> <engine>...
+//
+// In a gauntlet the first engine plays each of the others. Games need no
+// database or server.
+func cmdTournament(cfg *Config, args []string) error {
+	if len(args) == 0 || (args[0] != ModeRoundRobin && args[0] != ModeGauntlet) {
+		return errors.New("usage: tournament round-robin|gauntlet [-games n] ... <engine> <engine>...")
+	}
+	fs := flag.NewFlagSet("tournament "+args[0], flag.ContinueOnError)
+	games := fs.Int("games", 20, "games per pairing, half with each color")
+	workers := fs.Int("workers", runtime.NumCPU(), "games played at once")
+	seed := fs.Uint64("seed", 1, "seed for every engine's random choices")
+	budget := fs.Duration("budget", cfg.Game.BotBudget, "thinking time per move")
+	openings := fs.String("openings", "", "file of start positions, one per line, each played with both colors")
+	bookPath := fs.String("book", cfg.Game.OpeningBook, "opening book for the engines, empty for none")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	tc := TournamentConfig{
+		Engines: fs.Args(),
+		Mode:    args[0],
+		Games:   *games,
+		Workers: *workers,
+		Seed:    *seed,
+		Budget:  *budget,
+		Plies:   cfg.Game.BookPlies,
+	}
+	if *openings != "" {
+		var err error
+		if tc.Openings, err = readPositions(*openings); err != nil {
+			return err
+		}
+	}
+	if *bookPath != "" {
+		book, err := LoadBook(*bookPath)
+		if err != nil {
+			return err
+		}
+		tc.Book = book
+	}
+	res, err := RunTournament(tc)
+	if err != nil {
+		return err
+	}
+	return res.WriteReport(os.Stdout)
+}
+
+// readPositions reads one position per line, skipping blank lines and
+// lines starting with '#'
+func readPositions(path string) ([]Position, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var positions []Position
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pos, err := ParsePosition(line)
+		if err == nil && pos.Finished {
+			err = errors.New("position is already decided")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		positions = append(positions, pos)
+	}
+	return positions, nil
+}
+
+// cmdEngine runs a bot as an external engine, speaking the engine protocol
+// on stdin and stdout:
+//
+//	engine [engine-name]
+//
+// It plays game.bot_engine when no name is given, opening from
+// game.opening_book if set. It is the reference engine for the protocol.
+func cmdEngine(cfg *Config, args []string) error {
+	name := cfg.Game.BotEngine
+	if len(args) > 0 {
+		name = args[0]
+	}
+	engine, ok := NewBotEngine(name)
+	if !ok {
+		return fmt.Errorf("unknown engine %q, choose from %s", name, strings.Join(BotEngineNames(), ", "))
+	}
+	if cfg.Game.OpeningBook != "" && name != "random" {
+		book, err := LoadBook(cfg.Game.OpeningBook)
+		if err != nil {
+			return err
+		}
+		engine = WithOpeningBook(engine, book, cfg.Game.BookPlies, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+	}
+	return ServeEngineProtocol(os.Stdin, os.Stdout, engine)
+}
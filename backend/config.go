@@ -0,0 +1,612 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every tunable setting of the game server. Values are layered
+// as defaults, then the optional YAML file, then environment variables and
+// finally command line flags.
+type Config struct {
+	Server    ServerConfig    `yaml:"server"`
+	Mongo     MongoConfig     `yaml:"mongo"`
+	Kafka     KafkaConfig     `yaml:"kafka"`
+	Game      GameConfig      `yaml:"game"`
+	API       APIConfig       `yaml:"api"`
+	Log       LogConfig       `yaml:"log"`
+	Debug     DebugConfig     `yaml:"debug"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Retention RetentionConfig `yaml:"retention"`
+	Analysis  AnalysisConfig  `yaml:"analysis"`
+}
+
+type ServerConfig struct {
+	Addr          string        `yaml:"addr"`
+	ShutdownDrain time.Duration `yaml:"shutdown_drain"`
+}
+
+type MongoConfig struct {
+	URI         string `yaml:"uri"`
+	Database    string `yaml:"database"`
+	AutoMigrate bool   `yaml:"auto_migrate"` // apply pending migrations at startup
+}
+
+// KafkaConfig is shared with the analytics consumer, which reads the same
+// section of the config file
+type KafkaConfig struct {
+	Brokers []string `yaml:"brokers"`
+	Group   string   `yaml:"group"`
+}
+
+type GameConfig struct {
+	BotFallback     time.Duration `yaml:"bot_fallback"`
+	ResumeTimeout   time.Duration `yaml:"resume_timeout"` // wait for the opponent of a suspended game before aborting it
+	BotMoveDelay    time.Duration `yaml:"bot_move_delay"`
+	BotEngine       string        `yaml:"bot_engine"`        // engine for players who wait too long, see botEngines
+	BotBudget       time.Duration `yaml:"bot_budget"`        // thinking time per bot move
+	OpeningBook     string        `yaml:"opening_book"`      // book file bots open from, "" for none
+	BookPlies       int           `yaml:"book_plies"`        // plies played from the book
+	BotAccountGames int           `yaml:"bot_account_games"` // games each bot account may play at once
+	SendBuffer      int           `yaml:"send_buffer"`
+	SessionPolicy   string        `yaml:"session_policy"` // reject, replace or multi
+	HintLimit       int           `yaml:"hint_limit"`     // hints per bot game, 0 disables them
+	HintCooldown    time.Duration `yaml:"hint_cooldown"`  // minimum time between hints in a game
+	HintDepth       int           `yaml:"hint_depth"`     // plies searched for a hint
+
+	ExternalEngines  []ExternalEngineConfig       `yaml:"external_engines"`  // bots run as separate programs, see external.go
+	BotPersonalities map[string]PersonalityConfig `yaml:"bot_personalities"` // pace, mistakes, style and emotes by engine; an entry replaces that engine's defaults
+}
+
+type APIConfig struct {
+	LeaderboardLimit int `yaml:"leaderboard_limit"`
+	ResultsLimit     int `yaml:"results_limit"` // rows returned by /game_results
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"` // json or text
+}
+
+// DebugConfig guards the diagnostics endpoints. /debug/state is disabled
+// unless a token is set.
+type DebugConfig struct {
+	Token string `yaml:"token"`
+	Pprof bool   `yaml:"pprof"`
+}
+
+type AuthConfig struct {
+	Secret      string        `yaml:"secret"`
+	TokenTTL    time.Duration `yaml:"token_ttl"`
+	AllowGuests bool          `yaml:"allow_guests"`
+}
+
+// RetentionConfig controls the background job that aborts abandoned games
+// and archives old ones. A zero AbortAfter or ArchiveAfter disables that step.
+type RetentionConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Interval     time.Duration `yaml:"interval"`
+	AbortAfter   time.Duration `yaml:"abort_after"`
+	ArchiveAfter time.Duration `yaml:"archive_after"`
+	ArchiveDir   string        `yaml:"archive_dir"`
+	DryRun       bool          `yaml:"dry_run"` // only log what would change
+}
+
+// AnalysisConfig sizes the post-game analysis worker pool. Depth is how many
+// plies the engine looks ahead from each position.
+type AnalysisConfig struct {
+	Workers int `yaml:"workers"`
+	Queue   int `yaml:"queue"`
+	Depth   int `yaml:"depth"`
+}
+
+// DefaultConfig returns the settings the server used before they were configurable
+func DefaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:          ":8080",
+			ShutdownDrain: 30 * time.Second,
+		},
+		Mongo: MongoConfig{
+			URI:         "mongodb://localhost:27017",
+			Database:    "four_in_a_row",
+			AutoMigrate: true,
+		},
+		Kafka: KafkaConfig{
+			Group: "analytics-group",
+		},
+		Game: GameConfig{
+			BotFallback:     10 * time.Second,
+			ResumeTimeout:   2 * time.Minute,
+			BotMoveDelay:    350 * time.Millisecond,
+			BotEngine:       "heuristic",
+			BotBudget:       time.Second,
+			BookPlies:       8,
+			BotAccountGames: 4,
+			SendBuffer:      256,
+			SessionPolicy:   SessionReplace,
+			HintLimit:       3,
+			HintCooldown:    5 * time.Second,
+			HintDepth:       8,
+
+			BotPersonalities: defaultPersonalities(),
+		},
+		API: APIConfig{
+			LeaderboardLimit: 50,
+			ResultsLimit:     50,
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		Auth: AuthConfig{
+			TokenTTL:    7 * 24 * time.Hour,
+			AllowGuests: true,
+		},
+		Retention: RetentionConfig{
+			Enabled:    true,
+			Interval:   time.Hour,
+			AbortAfter: 24 * time.Hour,
+			ArchiveDir: "archive",
+		},
+		Analysis: AnalysisConfig{
+			Workers: 2,
+			Queue:   32,
+			Depth:   8,
+		},
+	}
+}
+
+// LoadConfig builds the configuration from args (without the program name).
+// It also returns the arguments left after the flags and whether
+// --print-config was given.
+func LoadConfig(args []string) (*Config, []string, bool, error) {
+	cfg := DefaultConfig()
+
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	printConfig := fs.Bool("print-config", false, "print the effective configuration and exit")
+	fs.StringVar(&cfg.Server.Addr, "addr", cfg.Server.Addr, "HTTP listen address")
+	fs.DurationVar(&cfg.Server.ShutdownDrain, "shutdown-drain", cfg.Server.ShutdownDrain, "time live games get to finish on shutdown")
+	fs.StringVar(&cfg.Mongo.URI, "mongo-uri", cfg.Mongo.URI, "MongoDB connection string")
+	fs.StringVar(&cfg.Mongo.Database, "db-name", cfg.Mongo.Database, "MongoDB database name")
+	fs.BoolVar(&cfg.Mongo.AutoMigrate, "auto-migrate", cfg.Mongo.AutoMigrate, "apply pending schema migrations at startup")
+	fs.Func("kafka-brokers", "comma separated Kafka brokers, empty disables events", func(v string) error {
+		cfg.Kafka.Brokers = splitList(v)
+		return nil
+	})
+	fs.DurationVar(&cfg.Game.BotFallback, "bot-fallback", cfg.Game.BotFallback, "wait before a queued player is matched with the bot")
+	fs.DurationVar(&cfg.Game.ResumeTimeout, "resume-timeout", cfg.Game.ResumeTimeout, "wait for the opponent of a suspended game before aborting it")
+	fs.DurationVar(&cfg.Game.BotMoveDelay, "bot-move-delay", cfg.Game.BotMoveDelay, "pause before the bot plays")
+	fs.StringVar(&cfg.Game.BotEngine, "bot-engine", cfg.Game.BotEngine, "bot engine matched with players who wait too long")
+	fs.DurationVar(&cfg.Game.BotBudget, "bot-budget", cfg.Game.BotBudget, "thinking time per bot move")
+	fs.StringVar(&cfg.Game.OpeningBook, "opening-book", cfg.Game.OpeningBook, "opening book file for the bots, empty for none")
+	fs.Func("external-engines", "comma separated name=command external bot engines", func(v string) error {
+		engines, err := parseExternalEngines(v)
+		cfg.Game.ExternalEngines = engines
+		return err
+	})
+	fs.IntVar(&cfg.Game.BookPlies, "book-plies", cfg.Game.BookPlies, "plies the bots play from the opening book")
+	fs.IntVar(&cfg.Game.BotAccountGames, "bot-account-games", cfg.Game.BotAccountGames, "games each bot account may play at once")
+	fs.IntVar(&cfg.Game.SendBuffer, "send-buffer", cfg.Game.SendBuffer, "outgoing WebSocket messages buffered per client")
+	fs.StringVar(&cfg.Game.SessionPolicy, "session-policy", cfg.Game.SessionPolicy, "second connection by the same user: reject, replace or multi")
+	fs.IntVar(&cfg.Game.HintLimit, "hint-limit", cfg.Game.HintLimit, "hints allowed per bot game, 0 disables them")
+	fs.DurationVar(&cfg.Game.HintCooldown, "hint-cooldown", cfg.Game.HintCooldown, "minimum time between hints in a game")
+	fs.IntVar(&cfg.Game.HintDepth, "hint-depth", cfg.Game.HintDepth, "plies searched for a hint")
+	fs.IntVar(&cfg.API.LeaderboardLimit, "leaderboard-limit", cfg.API.LeaderboardLimit, "maximum rows per leaderboard page")
+	fs.IntVar(&cfg.API.ResultsLimit, "results-limit", cfg.API.ResultsLimit, "recent results returned by /game_results")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "debug, info, warn or error")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "json or text")
+	fs.StringVar(&cfg.Debug.Token, "debug-token", cfg.Debug.Token, "bearer token for /debug endpoints, empty disables them")
+	fs.BoolVar(&cfg.Debug.Pprof, "pprof", cfg.Debug.Pprof, "serve pprof under /debug/pprof/ (requires -debug-token)")
+	fs.StringVar(&cfg.Auth.Secret, "auth-secret", cfg.Auth.Secret, "HMAC key for session tokens")
+	fs.DurationVar(&cfg.Auth.TokenTTL, "token-ttl", cfg.Auth.TokenTTL, "session token lifetime")
+	fs.BoolVar(&cfg.Auth.AllowGuests, "allow-guests", cfg.Auth.AllowGuests, "let players join without an account")
+	fs.BoolVar(&cfg.Retention.Enabled, "retention", cfg.Retention.Enabled, "run the retention job in the background")
+	fs.DurationVar(&cfg.Retention.Interval, "retention-interval", cfg.Retention.Interval, "time between retention runs")
+	fs.DurationVar(&cfg.Retention.AbortAfter, "abort-after", cfg.Retention.AbortAfter, "mark unfinished games aborted after this long, 0 disables")
+	fs.DurationVar(&cfg.Retention.ArchiveAfter, "archive-after", cfg.Retention.ArchiveAfter, "archive finished games older than this, 0 disables")
+	fs.StringVar(&cfg.Retention.ArchiveDir, "archive-dir", cfg.Retention.ArchiveDir, "directory for game archives")
+	fs.BoolVar(&cfg.Retention.DryRun, "retention-dry-run", cfg.Retention.DryRun, "log what retention would change without changing it")
+	fs.IntVar(&cfg.Analysis.Workers, "analysis-workers", cfg.Analysis.Workers, "games analyzed at once")
+	fs.IntVar(&cfg.Analysis.Queue, "analysis-queue", cfg.Analysis.Queue, "analyses waiting for a worker before requests are turned away")
+	fs.IntVar(&cfg.Analysis.Depth, "analysis-depth", cfg.Analysis.Depth, "plies the analysis engine searches")
+
+	// The first parse only discovers -config. Flags are applied a second
+	// time below so they win over the file and the environment.
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, false, err
+	}
+	cfg = DefaultConfig()
+	if *path != "" {
+		if err := cfg.loadFile(*path); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, nil, false, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, false, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, false, err
+	}
+	return &cfg, fs.Args(), *printConfig, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+
+	if err := yaml.NewDecoder(f).Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("config: parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	c.Server.Addr = getEnv("ADDR", c.Server.Addr)
+	c.Mongo.URI = getEnv("MONGO_URI", c.Mongo.URI)
+	c.Mongo.Database = getEnv("DB_NAME", c.Mongo.Database)
+	if v := os.Getenv("KAFKA_BROKERS"); v != "" {
+		c.Kafka.Brokers = splitList(v)
+	}
+	c.Kafka.Group = getEnv("KAFKA_GROUP", c.Kafka.Group)
+	c.Game.SessionPolicy = getEnv("SESSION_POLICY", c.Game.SessionPolicy)
+	c.Game.BotEngine = getEnv("BOT_ENGINE", c.Game.BotEngine)
+	c.Game.OpeningBook = getEnv("OPENING_BOOK", c.Game.OpeningBook)
+	if v := os.Getenv("EXTERNAL_ENGINES"); v != "" {
+		engines, err := parseExternalEngines(v)
+		if err != nil {
+			return fmt.Errorf("EXTERNAL_ENGINES: %w", err)
+		}
+		c.Game.ExternalEngines = engines
+	}
+	c.Log.Level = getEnv("LOG_LEVEL", c.Log.Level)
+	c.Log.Format = getEnv("LOG_FORMAT", c.Log.Format)
+	c.Debug.Token = getEnv("DEBUG_TOKEN", c.Debug.Token)
+	c.Auth.Secret = getEnv("AUTH_SECRET", c.Auth.Secret)
+	c.Retention.ArchiveDir = getEnv("ARCHIVE_DIR", c.Retention.ArchiveDir)
+
+	var err error
+	if c.Server.ShutdownDrain, err = getEnvDuration("SHUTDOWN_DRAIN", c.Server.ShutdownDrain); err != nil {
+		return err
+	}
+	if c.Game.BotFallback, err = getEnvDuration("BOT_FALLBACK", c.Game.BotFallback); err != nil {
+		return err
+	}
+	if c.Game.ResumeTimeout, err = getEnvDuration("RESUME_TIMEOUT", c.Game.ResumeTimeout); err != nil {
+		return err
+	}
+	if c.Game.BotMoveDelay, err = getEnvDuration("BOT_MOVE_DELAY", c.Game.BotMoveDelay); err != nil {
+		return err
+	}
+	if c.Game.BotBudget, err = getEnvDuration("BOT_BUDGET", c.Game.BotBudget); err != nil {
+		return err
+	}
+	if c.Game.BookPlies, err = getEnvInt("BOOK_PLIES", c.Game.BookPlies); err != nil {
+		return err
+	}
+	if c.Game.BotAccountGames, err = getEnvInt("BOT_ACCOUNT_GAMES", c.Game.BotAccountGames); err != nil {
+		return err
+	}
+	if c.Game.SendBuffer, err = getEnvInt("SEND_BUFFER", c.Game.SendBuffer); err != nil {
+		return err
+	}
+	if c.Game.HintLimit, err = getEnvInt("HINT_LIMIT", c.Game.HintLimit); err != nil {
+		return err
+	}
+	if c.Game.HintCooldown, err = getEnvDuration("HINT_COOLDOWN", c.Game.HintCooldown); err != nil {
+		return err
+	}
+	if c.Game.HintDepth, err = getEnvInt("HINT_DEPTH", c.Game.HintDepth); err != nil {
+		return err
+	}
+	if c.API.LeaderboardLimit, err = getEnvInt("LEADERBOARD_LIMIT", c.API.LeaderboardLimit); err != nil {
+		return err
+	}
+	if c.API.ResultsLimit, err = getEnvInt("RESULTS_LIMIT", c.API.ResultsLimit); err != nil {
+		return err
+	}
+	if c.Mongo.AutoMigrate, err = getEnvBool("MONGO_AUTO_MIGRATE", c.Mongo.AutoMigrate); err != nil {
+		return err
+	}
+	if c.Debug.Pprof, err = getEnvBool("PPROF", c.Debug.Pprof); err != nil {
+		return err
+	}
+	if c.Auth.TokenTTL, err = getEnvDuration("TOKEN_TTL", c.Auth.TokenTTL); err != nil {
+		return err
+	}
+	if c.Auth.AllowGuests, err = getEnvBool("ALLOW_GUESTS", c.Auth.AllowGuests); err != nil {
+		return err
+	}
+	if c.Retention.Enabled, err = getEnvBool("RETENTION", c.Retention.Enabled); err != nil {
+		return err
+	}
+	if c.Retention.Interval, err = getEnvDuration("RETENTION_INTERVAL", c.Retention.Interval); err != nil {
+		return err
+	}
+	if c.Retention.AbortAfter, err = getEnvDuration("ABORT_AFTER", c.Retention.AbortAfter); err != nil {
+		return err
+	}
+	if c.Retention.ArchiveAfter, err = getEnvDuration("ARCHIVE_AFTER", c.Retention.ArchiveAfter); err != nil {
+		return err
+	}
+	if c.Retention.DryRun, err = getEnvBool("RETENTION_DRY_RUN", c.Retention.DryRun); err != nil {
+		return err
+	}
+	if c.Analysis.Workers, err = getEnvInt("ANALYSIS_WORKERS", c.Analysis.Workers); err != nil {
+		return err
+	}
+	if c.Analysis.Queue, err = getEnvInt("ANALYSIS_QUEUE", c.Analysis.Queue); err != nil {
+		return err
+	}
+	if c.Analysis.Depth, err = getEnvInt("ANALYSIS_DEPTH", c.Analysis.Depth); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server.addr must not be empty"))
+	}
+	if c.Server.ShutdownDrain < 0 {
+		errs = append(errs, errors.New("server.shutdown_drain must not be negative"))
+	}
+	if c.Mongo.URI == "" {
+		errs = append(errs, errors.New("mongo.uri must not be empty"))
+	}
+	if c.Mongo.Database == "" {
+		errs = append(errs, errors.New("mongo.database must not be empty"))
+	}
+	for _, b := range c.Kafka.Brokers {
+		if !strings.Contains(b, ":") {
+			errs = append(errs, fmt.Errorf("kafka.brokers: %q is not host:port", b))
+		}
+	}
+	if c.Game.BotFallback <= 0 {
+		errs = append(errs, errors.New("game.bot_fallback must be positive"))
+	}
+	if c.Game.ResumeTimeout <= 0 {
+		errs = append(errs, errors.New("game.resume_timeout must be positive"))
+	}
+	if c.Game.BotMoveDelay < 0 {
+		errs = append(errs, errors.New("game.bot_move_delay must not be negative"))
+	}
+	external := map[string]bool{}
+	for i, e := range c.Game.ExternalEngines {
+		switch {
+		case !engineNamePattern.MatchString(e.Name):
+			errs = append(errs, fmt.Errorf("game.external_engines[%d]: name %q must be lowercase letters, digits and '-'", i, e.Name))
+		case external[e.Name]:
+			errs = append(errs, fmt.Errorf("game.external_engines[%d]: %q is listed twice", i, e.Name))
+		case botEngines[e.Name] != nil && externalPools[e.Name] == nil:
+			errs = append(errs, fmt.Errorf("game.external_engines[%d]: %q is a built-in engine", i, e.Name))
+		}
+		if len(e.Command) == 0 {
+			errs = append(errs, fmt.Errorf("game.external_engines[%d]: command is empty", i))
+		}
+		if e.Processes < 0 {
+			errs = append(errs, fmt.Errorf("game.external_engines[%d]: processes must not be negative", i))
+		}
+		external[e.Name] = true
+	}
+	if _, ok := botEngines[c.Game.BotEngine]; !ok && !external[c.Game.BotEngine] {
+		errs = append(errs, fmt.Errorf("game.bot_engine: %q is not one of %s", c.Game.BotEngine, strings.Join(BotEngineNames(), ", ")))
+	}
+	for name, p := range c.Game.BotPersonalities {
+		errs = append(errs, validatePersonality("game.bot_personalities."+name, p)...)
+	}
+	if c.Game.BotBudget <= 0 {
+		errs = append(errs, errors.New("game.bot_budget must be positive"))
+	}
+	if c.Game.BookPlies < 0 {
+		errs = append(errs, errors.New("game.book_plies must not be negative"))
+	}
+	if c.Game.BotAccountGames < 1 {
+		errs = append(errs, errors.New("game.bot_account_games must be at least 1"))
+	}
+	if c.Game.SendBuffer < 1 {
+		errs = append(errs, errors.New("game.send_buffer must be at least 1"))
+	}
+	if c.Game.HintLimit < 0 || c.Game.HintCooldown < 0 {
+		errs = append(errs, errors.New("game.hint_limit and game.hint_cooldown must not be negative"))
+	}
+	if c.Game.HintDepth < 1 || c.Game.HintDepth > 12 {
+		errs = append(errs, errors.New("game.hint_depth must be between 1 and 12"))
+	}
+	switch c.Game.SessionPolicy {
+	case SessionReject, SessionReplace, SessionMulti:
+	default:
+		errs = append(errs, fmt.Errorf("game.session_policy: %q is not reject, replace or multi", c.Game.SessionPolicy))
+	}
+	if c.API.LeaderboardLimit < 1 || c.API.LeaderboardLimit > 1000 {
+		errs = append(errs, errors.New("api.leaderboard_limit must be between 1 and 1000"))
+	}
+	if c.API.ResultsLimit < 1 || c.API.ResultsLimit > 1000 {
+		errs = append(errs, errors.New("api.results_limit must be between 1 and 1000"))
+	}
+	if _, err := parseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level: %q is not debug, info, warn or error", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log.format: %q is not json or text", c.Log.Format))
+	}
+	if c.Debug.Pprof && c.Debug.Token == "" {
+		errs = append(errs, errors.New("debug.pprof requires debug.token"))
+	}
+	if c.Auth.Secret != "" && len(c.Auth.Secret) < 32 {
+		errs = append(errs, errors.New("auth.secret must be at least 32 characters"))
+	}
+	if c.Auth.TokenTTL <= 0 {
+		errs = append(errs, errors.New("auth.token_ttl must be positive"))
+	}
+	if c.Retention.Enabled && c.Retention.Interval <= 0 {
+		errs = append(errs, errors.New("retention.interval must be positive"))
+	}
+	if c.Retention.AbortAfter < 0 || c.Retention.ArchiveAfter < 0 {
+		errs = append(errs, errors.New("retention.abort_after and retention.archive_after must not be negative"))
+	}
+	if c.Retention.ArchiveAfter > 0 && c.Retention.ArchiveDir == "" {
+		errs = append(errs, errors.New("retention.archive_dir is required when archive_after is set"))
+	}
+	if c.Analysis.Workers < 1 || c.Analysis.Queue < 1 {
+		errs = append(errs, errors.New("analysis.workers and analysis.queue must be at least 1"))
+	}
+	if c.Analysis.Depth < 1 || c.Analysis.Depth > 12 {
+		errs = append(errs, errors.New("analysis.depth must be between 1 and 12"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// redacted stands in for secrets when the configuration is printed
+const redacted = "<redacted>"
+
+// Print writes the effective configuration as YAML, with secrets that are
+// set replaced by redacted so the output is safe for logs
+func (c *Config) Print(w io.Writer) error {
+	out := *c
+	if out.Auth.Secret != "" {
+		out.Auth.Secret = redacted
+	}
+	if out.Debug.Token != "" {
+		out.Debug.Token = redacted
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(&out)
+}
+
+// getEnv reads an environment variable or returns fallback if not set
+func getEnv(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
+
+// getEnvDuration reads a duration such as "30s" from the environment
+func getEnvDuration(key string, fallback time.Duration) (time.Duration, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return 0, fmt.Errorf("config: %s: %w", key, err)
+	}
+	return d, nil
+}
+
+// getEnvInt reads an integer from the environment
+func getEnvInt(key string, fallback int) (int, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("config: %s: %w", key, err)
+	}
+	return n, nil
+}
+
+// getEnvBool reads a boolean such as "true" or "1" from the environment
+func getEnvBool(key string, fallback bool) (bool, error) {
+	val := os.Getenv(key)
+	if val == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, fmt.Errorf("config: %s: %w", key, err)
+	}
+	return b, nil
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func validatePersonality(key string, p PersonalityConfig) []error {
+	var errs []error
+	if p.ThinkMin < 0 || p.ThinkMean < 0 || p.ThinkMax < 0 {
+		errs = append(errs, fmt.Errorf("%s: think times must not be negative", key))
+	}
+	if p.ThinkMean > 0 && (p.ThinkMin > p.ThinkMean || p.ThinkMax > 0 && p.ThinkMean > p.ThinkMax) {
+		errs = append(errs, fmt.Errorf("%s: think_min, think_mean and think_max must be in order", key))
+	}
+	if p.ErrorRate < 0 || p.ErrorRate > 1 || p.EmoteChance < 0 || p.EmoteChance > 1 {
+		errs = append(errs, fmt.Errorf("%s: error_rate and emote_chance must be between 0 and 1", key))
+	}
+	if p.Attack < 0 || p.Defense < 0 {
+		errs = append(errs, fmt.Errorf("%s: attack and defense must not be negative", key))
+	}
+	for event := range p.Emotes {
+		if !slices.Contains(emoteEvents, event) {
+			errs = append(errs, fmt.Errorf("%s.emotes: %q is not one of %s", key, event, strings.Join(emoteEvents, ", ")))
+		}
+	}
+	return errs
+}
+
+// parseExternalEngines reads external engines written as name=command,
+// with the command split on spaces, separated by commas
+func parseExternalEngines(v string) ([]ExternalEngineConfig, error) {
+	var engines []ExternalEngineConfig
+	for _, item := range splitList(v) {
+		name, command, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not name=command", item)
+		}
+		engines = append(engines, ExternalEngineConfig{Name: strings.TrimSpace(name), Command: strings.Fields(command)})
+	}
+	return engines, nil
+}
+
+// mustLoadConfig loads the configuration for the server binary, handling
+// --print-config and exiting on invalid settings
+func mustLoadConfig(args []string) (*Config, []string) {
+	cfg, rest, printOnly, err := LoadConfig(args)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if printOnly {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+	return cfg, rest
+}
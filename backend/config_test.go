@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Auth.Secret = "a-very-secret-signing-key-of-32-chars"
+	cfg.Debug.Token = "debug-token-123"
+	var b strings.Builder
+	if err := cfg.Print(&b); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, secret := range []string{cfg.Auth.Secret, cfg.Debug.Token} {
+		if strings.Contains(out, secret) {
+			t.Errorf("printed config contains %q", secret)
+		}
+	}
+	if strings.Count(out, redacted) != 2 {
+		t.Errorf("want both secrets redacted in:\n%s", out)
+	}
+	if cfg.Auth.Secret == redacted {
+		t.Error("Print changed the config it printed")
+	}
+
+	// Unset secrets print as empty so it is clear they are missing
+	b.Reset()
+	plain := DefaultConfig()
+	if err := plain.Print(&b); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(b.String(), redacted) {
+		t.Errorf("unset secrets were redacted:\n%s", b.String())
+	}
+}
+
+func TestResultsLimitIsItsOwnSetting(t *testing.T) {
+	t.Setenv("RESULTS_LIMIT", "20")
+	cfg, _, _, err := LoadConfig([]string{"-leaderboard-limit", "200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.API.ResultsLimit != 20 || cfg.API.LeaderboardLimit != 200 {
+		t.Errorf("results_limit = %d and leaderboard_limit = %d, want 20 and 200", cfg.API.ResultsLimit, cfg.API.LeaderboardLimit)
+	}
+
+	cfg.API.ResultsLimit = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "api.results_limit") {
+		t.Errorf("Validate with results_limit 0 = %v, want an api.results_limit error", err)
+	}
+}
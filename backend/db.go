@@ -2,10 +2,11 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -15,25 +16,28 @@ type MongoDB struct {
 	Database *mongo.Database
 }
 
-// InitDB initializes the MongoDB connection and returns a MongoDB wrapper
-func InitDB() *MongoDB {
-	uri := getEnv("MONGO_URI", "mongodb://localhost:27017")
-	dbName := getEnv("DB_NAME", "four_in_a_row")
+// InitDB initializes the MongoDB connection and returns a MongoDB wrapper.
+// Every command is timed and reported to metrics.
+func InitDB(cfg MongoConfig, metrics Metrics) *MongoDB {
+	uri := cfg.URI
+	dbName := cfg.Database
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientOpts := options.Client().ApplyURI(uri)
+	clientOpts := options.Client().ApplyURI(uri).SetMonitor(commandMonitor(metrics))
 	client, err := mongo.Connect(ctx, clientOpts)
 	if err != nil {
-		log.Fatalf("❌ Failed to connect to MongoDB: %v", err)
+		slog.Error("failed to connect to MongoDB", "op", "db_connect", "error", err)
+		os.Exit(1)
 	}
 
 	if err = client.Ping(ctx, nil); err != nil {
-		log.Fatalf("❌ MongoDB ping failed: %v", err)
+		slog.Error("MongoDB ping failed", "op", "db_connect", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("✅ Connected to MongoDB successfully")
+	slog.Info("connected to MongoDB", "op", "db_connect", "database", dbName)
 	db := client.Database(dbName)
 	return &MongoDB{
 		Client:   client,
@@ -46,16 +50,20 @@ func (db *MongoDB) Disconnect() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := db.Client.Disconnect(ctx); err != nil {
-		log.Printf("❌ Error disconnecting MongoDB: %v", err)
+		slog.Error("failed to disconnect MongoDB", "op", "db_disconnect", "error", err)
 	} else {
-		log.Println("✅ MongoDB disconnected successfully")
+		slog.Info("MongoDB disconnected", "op", "db_disconnect")
 	}
 }
 
-// getEnv reads an environment variable or returns fallback if not set
-func getEnv(key, fallback string) string {
-	if val := os.Getenv(key); val != "" {
-		return val
+// commandMonitor reports the latency and failures of every MongoDB command
+func commandMonitor(metrics Metrics) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			metrics.DBCommand(e.CommandName, e.Duration, false)
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			metrics.DBCommand(e.CommandName, e.Duration, true)
+		},
 	}
-	return fallback
 }
@@ -0,0 +1,348 @@
+package main
+
+import "time"
+
+// The engine is a depth limited negamax search with alpha-beta pruning.
+// Scores are from the point of view of the side to move. A forced win is
+// worth WinScore less the plies needed to play the winning disc, so quicker
+// wins score higher; scores within maxPlies of WinScore are forced results
+// and everything else is a heuristic estimate in the low hundreds.
+const (
+	WinScore = 10000
+	maxPlies = Rows * Cols
+)
+
+// searchOrder tries central columns first, which prunes far more
+var searchOrder = [Cols]int{3, 2, 4, 1, 5, 0, 6}
+
+// IsForcedWin and IsForcedLoss report whether a score is a proven result
+// rather than an estimate
+func IsForcedWin(score int) bool  { return score > WinScore-maxPlies-1 }
+func IsForcedLoss(score int) bool { return score < -(WinScore - maxPlies - 1) }
+
+// searchBoard is a position the search can play and take back moves on
+type searchBoard struct {
+	cells   [Rows][Cols]Player
+	heights [Cols]int // discs in each column
+	turn    Player
+	discs   int
+	key     ttKey // discs of each player as bitboards, see cellBit
+
+	// The heuristic weighs side's open lines by attack and its opponent's
+	// by defense, in percent; side is whoever moves at the root
+	side            Player
+	attack, defense int
+
+	// A search with a deadline gives up once it passes, setting stopped;
+	// scores from a stopped search are meaningless
+	deadline time.Time
+	nodes    int
+	stopped  bool
+	tt       map[ttKey]ttEntry // nil disables the transposition table
+}
+
+// ttKey identifies a position by the cells each player holds
+type ttKey [2]uint64
+
+// ttEntry is a score stored with how deep it was searched and whether it is
+// exact or only a bound
+type ttEntry struct {
+	depth int
+	score int
+	bound int8 // 0 exact, 1 lower bound, -1 upper bound
+}
+
+// maxTTEntries caps the transposition table; it starts over when full
+const maxTTEntries = 1 << 20
+
+func cellBit(r, c int) uint64 { return 1 << uint(c*Rows+r) }
+
+func newSearchBoard(pos Position) *searchBoard {
+	b := &searchBoard{cells: pos.Board, turn: pos.Turn, side: pos.Turn, attack: 100, defense: 100}
+	for c := 0; c < Cols; c++ {
+		for r := Rows - 1; r >= 0 && b.cells[r][c] != Empty; r-- {
+			b.key[b.cells[r][c]-1] |= cellBit(r, c)
+			b.heights[c]++
+			b.discs++
+		}
+	}
+	return b
+}
+
+// Style leans the search towards building its own lines or breaking up the
+// opponent's. Weights are relative to 1; zero counts as 1.
+type Style struct {
+	Attack  float64
+	Defense float64
+}
+
+func (b *searchBoard) setStyle(s Style) {
+	percent := func(w float64) int {
+		if w == 0 {
+			return 100
+		}
+		return int(w * 100)
+	}
+	b.attack, b.defense = percent(s.Attack), percent(s.Defense)
+}
+
+func opponent(p Player) Player {
+	if p == P1 {
+		return P2
+	}
+	return P1
+}
+
+func (b *searchBoard) canPlay(c int) bool { return b.heights[c] < Rows }
+
+func (b *searchBoard) play(c int) {
+	r := Rows - 1 - b.heights[c]
+	b.cells[r][c] = b.turn
+	b.key[b.turn-1] |= cellBit(r, c)
+	b.heights[c]++
+	b.discs++
+	b.turn = opponent(b.turn)
+}
+
+func (b *searchBoard) undo(c int) {
+	b.heights[c]--
+	b.discs--
+	b.turn = opponent(b.turn)
+	r := Rows - 1 - b.heights[c]
+	b.cells[r][c] = Empty
+	b.key[b.turn-1] &^= cellBit(r, c)
+}
+
+// wins reports whether the side to move wins by playing c
+func (b *searchBoard) wins(c int) bool {
+	r := Rows - 1 - b.heights[c]
+	b.cells[r][c] = b.turn
+	won := fourAt(&b.cells, r, c, b.turn)
+	b.cells[r][c] = Empty
+	return won
+}
+
+// negamax scores the position to depth plies. ply is the distance from the
+// root, used to prefer quicker wins.
+func (b *searchBoard) negamax(depth, alpha, beta, ply int) int {
+	b.nodes++
+	if !b.deadline.IsZero() && b.nodes%1024 == 0 && time.Now().After(b.deadline) {
+		b.stopped = true
+	}
+	if b.stopped {
+		return 0
+	}
+	if b.discs == Rows*Cols {
+		return 0
+	}
+	for _, c := range searchOrder {
+		if b.canPlay(c) && b.wins(c) {
+			return WinScore - ply - 1
+		}
+	}
+	if depth == 0 {
+		return b.heuristic()
+	}
+
+	// Below one root a position is always reached at the same ply, since
+	// that follows from its number of discs, so stored win scores stay valid
+	// as long as the table is not reused for another root
+	alphaIn := alpha
+	if b.tt != nil {
+		if e, ok := b.tt[b.key]; ok && e.depth >= depth {
+			switch {
+			case e.bound == 0:
+				return e.score
+			case e.bound > 0 && e.score > alpha:
+				alpha = e.score
+			case e.bound < 0 && e.score < beta:
+				beta = e.score
+			}
+			if alpha >= beta {
+				return e.score
+			}
+		}
+	}
+
+	best := -WinScore
+	for _, c := range searchOrder {
+		if !b.canPlay(c) {
+			continue
+		}
+		b.play(c)
+		score := -b.negamax(depth-1, -beta, -alpha, ply+1)
+		b.undo(c)
+		if score > best {
+			best = score
+		}
+		if score > alpha {
+			alpha = score
+		}
+		if alpha >= beta {
+			break
+		}
+	}
+	if b.tt != nil && !b.stopped {
+		e := ttEntry{depth: depth, score: best}
+		switch {
+		case best <= alphaIn:
+			e.bound = -1
+		case best >= beta:
+			e.bound = 1
+		}
+		if len(b.tt) >= maxTTEntries {
+			clear(b.tt)
+		}
+		b.tt[b.key] = e
+	}
+	return best
+}
+
+// heuristic counts the lines of four that are still open for each side,
+// weighting those closer to completion, plus a bonus for central discs
+func (b *searchBoard) heuristic() int {
+	me, them := b.turn, opponent(b.turn)
+	mineWeight, theirsWeight := b.attack, b.defense
+	if me != b.side {
+		mineWeight, theirsWeight = theirsWeight, mineWeight
+	}
+	score := 0
+	for r := 0; r < Rows; r++ {
+		switch b.cells[r][Cols/2] {
+		case me:
+			score += 3
+		case them:
+			score -= 3
+		}
+	}
+	dirs := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
+	own, opp := 0, 0
+	for r := 0; r < Rows; r++ {
+		for c := 0; c < Cols; c++ {
+			for _, d := range dirs {
+				er, ec := r+3*d[0], c+3*d[1]
+				if er < 0 || er >= Rows || ec < 0 || ec >= Cols {
+					continue
+				}
+				mine, theirs := 0, 0
+				for i := 0; i < 4; i++ {
+					switch b.cells[r+i*d[0]][c+i*d[1]] {
+					case me:
+						mine++
+					case them:
+						theirs++
+					}
+				}
+				switch {
+				case theirs == 0:
+					own += lineWeights[mine]
+				case mine == 0:
+					opp += lineWeights[theirs]
+				}
+			}
+		}
+	}
+	return score + (own*mineWeight-opp*theirsWeight)/100
+}
+
+// lineWeights scores an open line of four by how many discs it holds
+var lineWeights = [4]int{0, 1, 4, 16}
+
+// ScoreMoves searches every legal move of pos to depth plies, counting the
+// move itself, and returns each move's score from the point of view of the
+// side to move. Full columns are left out; a finished position has no moves.
+func ScoreMoves(pos Position, depth int) map[int]int {
+	scores := map[int]int{}
+	if pos.Finished {
+		return scores
+	}
+	b := newSearchBoard(pos)
+	for _, c := range searchOrder {
+		if !b.canPlay(c) {
+			continue
+		}
+		if b.wins(c) {
+			scores[c] = WinScore - 1
+			continue
+		}
+		b.play(c)
+		scores[c] = -b.negamax(depth-1, -WinScore, WinScore, 1)
+		b.undo(c)
+	}
+	return scores
+}
+
+// BestMove returns the highest scoring column and its score, preferring
+// central columns on ties. ok is false when there is no legal move.
+func BestMove(scores map[int]int) (col, score int, ok bool) {
+	for _, c := range searchOrder {
+		s, legal := scores[c]
+		if legal && (!ok || s > score) {
+			col, score, ok = c, s, true
+		}
+	}
+	return col, score, ok
+}
+
+// searchBest deepens the search of pos one ply at a time, up to maxDepth or
+// until deadline, and returns the best move of the deepest search that
+// finished. The one ply search always finishes, so there is a move even if
+// the deadline has passed. It stops early once the result is forced. ok is
+// false when pos has no legal move.
+func searchBest(pos Position, maxDepth int, deadline time.Time, useTT bool, style Style) (col, score, depth int, ok bool) {
+	if pos.Finished {
+		return 0, 0, 0, false
+	}
+	b := newSearchBoard(pos)
+	b.setStyle(style)
+	if useTT {
+		b.tt = make(map[ttKey]ttEntry)
+	}
+	if left := Rows*Cols - b.discs; maxDepth > left {
+		maxDepth = left
+	}
+	order := searchOrder
+	for d := 1; d <= maxDepth; d++ {
+		if d > 1 {
+			b.deadline = deadline
+		}
+		bestCol, bestScore, found := 0, 0, false
+		for _, c := range order {
+			if !b.canPlay(c) {
+				continue
+			}
+			s := WinScore - 1
+			if !b.wins(c) {
+				alpha := -WinScore
+				if found {
+					alpha = bestScore
+				}
+				b.play(c)
+				s = -b.negamax(d-1, -WinScore, -alpha, 1)
+				b.undo(c)
+			}
+			if b.stopped {
+				break
+			}
+			if !found || s > bestScore {
+				bestCol, bestScore, found = c, s, true
+			}
+		}
+		if b.stopped || !found {
+			break
+		}
+		col, score, depth, ok = bestCol, bestScore, d, true
+		if IsForcedWin(score) || IsForcedLoss(score) {
+			break
+		}
+		// Search the best move first next time, it tightens the window
+		for i, c := range order {
+			if c == bestCol {
+				copy(order[1:i+1], order[:i])
+				order[0] = bestCol
+				break
+			}
+		}
+	}
+	return col, score, depth, ok
+}
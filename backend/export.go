@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errGameArchived is returned for games whose moves were moved to an archive
+var errGameArchived = errors.New("game archived")
+
+// recordOf builds the portable record of a stored game. res may be nil for
+// games that never finished.
+func recordOf(g GameDB, res *GameResult) GameRecord {
+	rec := GameRecord{
+		GameID:      g.GameID,
+		Date:        g.StartedAt,
+		Player1:     g.Player1,
+		Player2:     g.Player2,
+		Result:      g.Outcome,
+		Variant:     VariantClassic,
+		TimeControl: "-",
+		Moves:       g.MoveLog,
+		Position:    g.Start,
+	}
+	if res != nil {
+		rec.Result = res.Outcome
+		if res.Variant != "" {
+			rec.Variant = res.Variant
+		}
+		if res.Forfeit {
+			rec.Termination = TerminationForfeit
+		}
+	}
+	if g.Aborted {
+		rec.Result, rec.Termination = ResultNone, TerminationAbandoned
+	}
+	return rec
+}
+
+// GameRecord loads one stored game in portable form
+func (db *MongoDB) GameRecord(ctx context.Context, gameID string) (GameRecord, error) {
+	var g GameDB
+	err := db.Database.Collection("games").FindOne(ctx, bson.M{"game_id": gameID}).Decode(&g)
+	if err == mongo.ErrNoDocuments {
+		if archive, _ := db.archiveOf(ctx, gameID); archive != "" {
+			return GameRecord{}, fmt.Errorf("%w in %s", errGameArchived, archive)
+		}
+		return GameRecord{}, err
+	}
+	if err != nil {
+		return GameRecord{}, err
+	}
+
+	var res GameResult
+	err = db.Database.Collection("game_results").FindOne(ctx, bson.M{"game_id": gameID}).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return recordOf(g, nil), nil
+	}
+	if err != nil {
+		return GameRecord{}, err
+	}
+	return recordOf(g, &res), nil
+}
+
+// ExportPlayerGames writes every finished game of a player, oldest first,
+// and returns how many it wrote. Archived games, and games from before move
+// logs were kept, are skipped.
+func (db *MongoDB) ExportPlayerGames(ctx context.Context, w io.Writer, playerID string) (int, error) {
+	cursor, err := db.Database.Collection("game_results").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": bson.A{bson.M{"player1_id": playerID}, bson.M{"player2_id": playerID}}}}},
+		{{Key: "$sort", Value: bson.M{"created_at": 1}}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         "games",
+			"localField":   "game_id",
+			"foreignField": "game_id",
+			"as":           "game",
+		}}},
+		{{Key: "$unwind", Value: "$game"}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	n := 0
+	for cursor.Next(ctx) {
+		var row struct {
+			GameResult `bson:",inline"`
+			Game       GameDB `bson:"game"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return n, err
+		}
+		if len(row.Game.MoveLog) == 0 {
+			continue
+		}
+		if err := WriteGame(w, recordOf(row.Game, &row.GameResult)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, cursor.Err()
+}
+
+// ImportGame stores a game read from portable notation after replaying it.
+// Imported games only go into the games collection, so they can be replayed
+// and analysed without counting towards anyone's results. A game whose ID is
+// already taken gets a new one.
+func (db *MongoDB) ImportGame(ctx context.Context, rec GameRecord) (string, error) {
+	g, err := rec.Rebuild()
+	if err != nil {
+		return "", err
+	}
+	if rec.GameID == "" {
+		rec.GameID = uuid.NewString()
+	}
+
+	// Unfinished imports are positions to study, not games anyone will
+	// resume, so they are stored as finished
+	now := time.Now()
+	doc := GameDB{
+		GameID:    rec.GameID,
+		Player1:   g.Player1,
+		Player2:   g.Player2,
+		StartedAt: g.StartedAt,
+		Finished:  true,
+		Winner:    g.WinnerName(),
+		Outcome:   g.Result,
+		Aborted:   rec.Termination == TerminationAbandoned,
+		Imported:  true,
+		MoveLog:   g.History,
+		Start:     g.Start,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	coll := db.Database.Collection("games")
+	_, err = coll.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		doc.GameID = uuid.NewString()
+		_, err = coll.InsertOne(ctx, doc)
+	}
+	return doc.GameID, err
+}
+
+// registerExportRoutes mounts GET /games/{id}/export and
+// GET /players/{username}/export
+func registerExportRoutes(mux *http.ServeMux, db *MongoDB) {
+	mux.HandleFunc("GET /games/{id}/export", withRequestLog("export_game", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		rec, err := db.GameRecord(ctx, id)
+		switch {
+		case errors.Is(err, errGameArchived):
+			writeError(w, http.StatusGone, err.Error())
+			return
+		case err == mongo.ErrNoDocuments:
+			writeError(w, http.StatusNotFound, "game not found")
+			return
+		case err != nil:
+			requestLogger(r, "export_game").Error("game lookup failed", "game_id", id, "error", err)
+			writeError(w, http.StatusInternalServerError, "lookup failed")
+			return
+		}
+		setNotationHeaders(w, id)
+		WriteGame(w, rec)
+	}))
+
+	mux.HandleFunc("GET /players/{username}/export", withRequestLog("export_player", func(w http.ResponseWriter, r *http.Request) {
+		reqLog := requestLogger(r, "export_player")
+		username := r.PathValue("username")
+		ctx, cancel := context.WithTimeout(r.Context(), time.Minute)
+		defer cancel()
+
+		st, err := db.playerByName(ctx, username)
+		if err == mongo.ErrNoDocuments {
+			writeError(w, http.StatusNotFound, "player not found")
+			return
+		}
+		if err != nil {
+			reqLog.Error("player stats lookup failed", "username", username, "error", err)
+			writeError(w, http.StatusInternalServerError, "lookup failed")
+			return
+		}
+		setNotationHeaders(w, username)
+		// The status goes out with the first game, so a failure part way
+		// through can only cut the file short
+		if _, err := db.ExportPlayerGames(ctx, w, st.PlayerID); err != nil {
+			reqLog.Error("export failed", "username", username, "error", err)
+		}
+	}))
+}
+
+func setNotationHeaders(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".c4n"))
+	setCORSHeaders(w)
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The engine protocol lets bots written in any language play. An engine is
+// a program that reads commands from stdin and answers on stdout, one line
+// each, in the spirit of UCI:
+//
+//	c4i                       start of the session; answer with optional
+//	                          "id name <name>" and then "c4iok"
+//	isready                   answer "readyok" once ready for commands
+//	position <position>       the position to search, in the one line
+//	                          notation of ParsePosition; it is complete, so
+//	                          engines need not remember earlier positions
+//	go movetime <ms>          search the last position for at most ms
+//	                          milliseconds and answer "bestmove <column>"
+//	                          with the column numbered 1 to 7
+//	quit                      exit
+//
+// Engines may send "info <anything>" lines at any time; they are logged at
+// debug level. Other unknown lines are ignored both ways.
+
+const (
+	engineStartTimeout = 5 * time.Second
+	engineGrace        = 500 * time.Millisecond // allowed past movetime before a search is given up
+	engineQuitWait     = time.Second            // before an engine that was told to quit is killed
+)
+
+var (
+	errEngineExited  = errors.New("engine exited")
+	errEngineTimeout = errors.New("engine did not answer in time")
+	errEngineBusy    = errors.New("every engine process is busy")
+	errEngineClosed  = errors.New("engine is shut down")
+)
+
+// ExternalEngineConfig is a bot engine run as its own program speaking the
+// engine protocol
+type ExternalEngineConfig struct {
+	Name      string   `yaml:"name"`
+	Command   []string `yaml:"command"`   // program and arguments
+	Processes int      `yaml:"processes"` // engine processes run at most, one search each; 0 for 1
+}
+
+// engineNamePattern keeps engine names fit for bot player names and URLs
+var engineNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// externalPools holds the process pool of every registered external engine
+var (
+	externalMu    sync.Mutex
+	externalPools = map[string]*enginePool{}
+)
+
+// RegisterExternalEngines adds the external engines to the registry so they
+// can be chosen like the built-in ones. Call it once, before any game starts.
+func RegisterExternalEngines(cfgs []ExternalEngineConfig) {
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	for _, cfg := range cfgs {
+		pool := newEnginePool(cfg)
+		externalPools[cfg.Name] = pool
+		botEngines[cfg.Name] = func(*rand.Rand) BotEngine {
+			return &externalEngine{pool: pool, fallback: heuristicEngine{}}
+		}
+	}
+}
+
+// CloseExternalEngines tells every running engine process to quit
+func CloseExternalEngines() {
+	externalMu.Lock()
+	defer externalMu.Unlock()
+	for _, pool := range externalPools {
+		pool.close()
+	}
+}
+
+// externalEngine asks an engine process for each move. Should the engine
+// fail, time out or answer with an illegal move, the heuristic plays instead
+// so the game goes on.
+type externalEngine struct {
+	pool     *enginePool
+	fallback BotEngine
+}
+
+func (e *externalEngine) Name() string { return e.pool.cfg.Name }
+
+func (e *externalEngine) ChooseMove(pos Position, budget time.Duration) int {
+	col, err := e.pool.bestMove(pos, budget)
+	if err == nil && (col < 0 || col >= Cols || pos.Board[0][col] != Empty) {
+		err = fmt.Errorf("illegal move in column %d", col+1)
+	}
+	if err != nil {
+		e.pool.log.Warn("external engine failed, the heuristic plays instead", "op", "bot_move", "position", pos.String(), "error", err)
+		return e.fallback.ChooseMove(pos, budget)
+	}
+	return col
+}
+
+// enginePool runs up to cfg.Processes engine processes, started on demand
+// and kept between searches
+type enginePool struct {
+	cfg   ExternalEngineConfig
+	idle  chan *engineProcess
+	slots chan struct{} // one token per running process
+	log   *slog.Logger
+
+	mu     sync.Mutex // guards closed and puts on idle
+	closed bool
+	done   chan struct{} // closed by close, wakes searches waiting for a process
+}
+
+func newEnginePool(cfg ExternalEngineConfig) *enginePool {
+	n := max(1, cfg.Processes)
+	return &enginePool{
+		cfg:   cfg,
+		idle:  make(chan *engineProcess, n),
+		slots: make(chan struct{}, n),
+		done:  make(chan struct{}),
+		log:   slog.Default().With("component", "external_engine", "bot", cfg.Name),
+	}
+}
+
+// bestMove searches pos on an engine process. A process that crashes is
+// replaced and asked again if the budget allows; one that overruns it is
+// killed.
+func (p *enginePool) bestMove(pos Position, budget time.Duration) (int, error) {
+	deadline := time.Now().Add(budget)
+	for attempt := 0; ; attempt++ {
+		proc, err := p.acquire(deadline)
+		if err != nil {
+			return 0, err
+		}
+		col, err := proc.bestMove(pos, max(time.Until(deadline), time.Millisecond))
+		if err == nil {
+			p.release(proc)
+			return col, nil
+		}
+		p.discard(proc)
+		if !errors.Is(err, errEngineExited) || attempt > 0 || time.Now().After(deadline) {
+			return 0, err
+		}
+		p.log.Warn("engine crashed, restarting it", "op", "bot_move", "error", proc.exitErr())
+	}
+}
+
+// acquire returns an idle process, starting one if fewer than
+// cfg.Processes run, or waits for one until deadline. Once the pool is
+// closed it returns errEngineClosed.
+func (p *enginePool) acquire(deadline time.Time) (*engineProcess, error) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		return nil, errEngineClosed
+	}
+	select {
+	case proc := <-p.idle:
+		return proc, nil
+	default:
+	}
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case proc := <-p.idle:
+		return proc, nil
+	case p.slots <- struct{}{}:
+		proc, err := startEngineProcess(p.cfg, p.log)
+		if err != nil {
+			<-p.slots
+			return nil, err
+		}
+		// close may have run while the process started
+		p.mu.Lock()
+		closed := p.closed
+		p.mu.Unlock()
+		if closed {
+			proc.quit()
+			<-p.slots
+			return nil, errEngineClosed
+		}
+		return proc, nil
+	case <-p.done:
+		return nil, errEngineClosed
+	case <-timer.C:
+		return nil, errEngineBusy
+	}
+}
+
+// release returns a healthy process to the pool, or stops it once the pool
+// is closed. The put happens under p.mu so close cannot miss it.
+func (p *enginePool) release(proc *engineProcess) {
+	p.mu.Lock()
+	if !p.closed {
+		p.idle <- proc
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+	proc.quit()
+	<-p.slots
+}
+
+// discard kills a process that crashed or overran and frees its slot
+func (p *enginePool) discard(proc *engineProcess) {
+	proc.kill()
+	<-p.slots
+}
+
+// close stops the idle processes and makes further searches fail; busy
+// processes are stopped as they are released
+func (p *enginePool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	var idle []*engineProcess
+	for len(p.idle) > 0 {
+		idle = append(idle, <-p.idle)
+	}
+	p.mu.Unlock()
+	for _, proc := range idle {
+		proc.quit()
+		<-p.slots
+	}
+}
+
+// engineProcess is one running engine
+type engineProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	lines  chan string   // stdout, closed once the engine exits
+	exited chan struct{} // closed once the engine has been waited for
+	err    error         // exit status, set before exited is closed
+	log    *slog.Logger
+}
+
+// startEngineProcess starts cfg's program and runs the c4i handshake
+func startEngineProcess(cfg ExternalEngineConfig, log *slog.Logger) (*engineProcess, error) {
+	cmd := exec.Command(cfg.Command[0], cfg.Command[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = &engineLogWriter{log: log}
+	cmd.WaitDelay = engineQuitWait
+	setEngineProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting engine: %w", err)
+	}
+	proc := &engineProcess{
+		cmd:    cmd,
+		stdin:  stdin,
+		lines:  make(chan string, 64),
+		exited: make(chan struct{}),
+		log:    log.With("pid", cmd.Process.Pid),
+	}
+	go func() {
+		sc := bufio.NewScanner(stdout)
+		for sc.Scan() {
+			proc.lines <- sc.Text()
+		}
+		close(proc.lines)
+		// Wait closes stdout, so it must come after the last read
+		proc.err = cmd.Wait()
+		close(proc.exited)
+	}()
+
+	proc.send("c4i")
+	name, err := proc.await("c4iok", engineStartTimeout)
+	if err != nil {
+		proc.kill()
+		return nil, fmt.Errorf("engine handshake: %w", err)
+	}
+	proc.log.Info("engine started", "op", "engine_start", "engine_name", name)
+	return proc, nil
+}
+
+// await reads lines until one starts with want and returns the name from any
+// "id name" line on the way
+func (proc *engineProcess) await(want string, timeout time.Duration) (string, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	name := ""
+	for {
+		select {
+		case line, ok := <-proc.lines:
+			if !ok {
+				return name, errEngineExited
+			}
+			if rest, found := strings.CutPrefix(line, "id name "); found {
+				name = rest
+			}
+			if f := strings.Fields(line); len(f) > 0 && f[0] == want {
+				return name, nil
+			}
+			proc.logLine(line)
+		case <-timer.C:
+			return name, errEngineTimeout
+		}
+	}
+}
+
+// bestMove asks for the best move of pos, allowing engineGrace past budget
+func (proc *engineProcess) bestMove(pos Position, budget time.Duration) (int, error) {
+	// Anything left over from before belongs to no search
+	for drained := false; !drained; {
+		select {
+		case line, ok := <-proc.lines:
+			if !ok {
+				return 0, errEngineExited
+			}
+			proc.logLine(line)
+		default:
+			drained = true
+		}
+	}
+	proc.send("position " + pos.String())
+	proc.send("go movetime " + strconv.FormatInt(budget.Milliseconds(), 10))
+
+	timer := time.NewTimer(budget + engineGrace)
+	defer timer.Stop()
+	for {
+		select {
+		case line, ok := <-proc.lines:
+			if !ok {
+				return 0, errEngineExited
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 || fields[0] != "bestmove" {
+				proc.logLine(line)
+				continue
+			}
+			if len(fields) < 2 {
+				return 0, errors.New("bestmove without a column")
+			}
+			col, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0, fmt.Errorf("bestmove %q is not a column", fields[1])
+			}
+			return col - 1, nil
+		case <-timer.C:
+			return 0, errEngineTimeout
+		}
+	}
+}
+
+func (proc *engineProcess) send(line string) {
+	// A failed write means the engine is gone, which the read side reports
+	io.WriteString(proc.stdin, line+"\n")
+}
+
+func (proc *engineProcess) logLine(line string) {
+	proc.log.Debug("engine output", "op", "bot_move", "line", line)
+}
+
+// quit asks the engine to exit and kills it if it does not
+func (proc *engineProcess) quit() {
+	proc.send("quit")
+	proc.stdin.Close()
+	select {
+	case <-proc.exited:
+	case <-time.After(engineQuitWait):
+		proc.kill()
+	}
+}
+
+func (proc *engineProcess) kill() {
+	proc.stdin.Close()
+	killEngine(proc.cmd)
+	// Unread output would keep the reader, and so Wait, from finishing
+	go func() {
+		for range proc.lines {
+		}
+	}()
+}
+
+// exitErr returns how the engine exited, once it has
+func (proc *engineProcess) exitErr() error {
+	select {
+	case <-proc.exited:
+		return proc.err
+	case <-time.After(100 * time.Millisecond):
+		return errEngineExited
+	}
+}
+
+// engineLogWriter logs each line an engine writes to stderr
+type engineLogWriter struct {
+	log *slog.Logger
+	buf []byte
+}
+
+func (w *engineLogWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log.Warn("engine stderr", "op", "bot_move", "line", string(w.buf[:i]))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// ServeEngineProtocol plays engine over the engine protocol, reading
+// commands from r and answering on w until quit or the end of input. It is
+// the reference implementation of the protocol.
+func ServeEngineProtocol(r io.Reader, w io.Writer, engine BotEngine) error {
+	out := bufio.NewWriter(w)
+	reply := func(format string, args ...any) error {
+		fmt.Fprintf(out, format+"\n", args...)
+		return out.Flush()
+	}
+
+	var pos *Position
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		cmd, args, _ := strings.Cut(strings.TrimSpace(sc.Text()), " ")
+		var err error
+		switch cmd {
+		case "":
+		case "c4i":
+			if err = reply("id name %s", BotPlayerName(engine.Name())); err == nil {
+				err = reply("c4iok")
+			}
+		case "isready":
+			err = reply("readyok")
+		case "position":
+			p, perr := ParsePosition(args)
+			if perr == nil && p.Finished {
+				perr = errors.New("position is already decided")
+			}
+			if perr != nil {
+				pos = nil
+				err = reply("info string invalid position: %v", perr)
+				break
+			}
+			pos = &p
+		case "go":
+			budget := time.Second
+			if f := strings.Fields(args); len(f) == 2 && f[0] == "movetime" {
+				if ms, perr := strconv.Atoi(f[1]); perr == nil && ms > 0 {
+					budget = time.Duration(ms) * time.Millisecond
+				}
+			}
+			if pos == nil {
+				err = reply("info string no position to search")
+				break
+			}
+			err = reply("bestmove %d", engine.ChooseMove(*pos, budget)+1)
+		case "quit":
+			return nil
+		default:
+			err = reply("info string unknown command %s", cmd)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+func setEngineProcessGroup(cmd *exec.Cmd) {}
+
+func killEngine(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The tests run their own binary as the engine: with testEngineEnv set,
+// TestMain serves the engine protocol instead of running tests.
+const (
+	testEngineEnv  = "FOURINAROW_TEST_ENGINE"
+	testEngineMark = "FOURINAROW_TEST_ENGINE_MARK" // crash-once: file made by the first process
+)
+
+func TestMain(m *testing.M) {
+	if mode := os.Getenv(testEngineEnv); mode != "" {
+		if err := ServeEngineProtocol(os.Stdin, os.Stdout, testEngine{mode: mode}); err != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// testEngine plays column 3 (the fourth) unless its mode says otherwise:
+// "hang" never answers, "crash" exits when asked to search and "crash-once"
+// does so only in the first process started
+type testEngine struct{ mode string }
+
+func (e testEngine) Name() string { return "test" }
+
+func (e testEngine) ChooseMove(Position, time.Duration) int {
+	switch e.mode {
+	case "hang":
+		select {}
+	case "crash":
+		os.Exit(3)
+	case "crash-once":
+		mark := os.Getenv(testEngineMark)
+		if _, err := os.Stat(mark); errors.Is(err, os.ErrNotExist) {
+			os.WriteFile(mark, nil, 0o644)
+			os.Exit(3)
+		}
+	}
+	return 3
+}
+
+// testEngineConfig runs the test binary as an engine in mode
+func testEngineConfig(t *testing.T, mode string) ExternalEngineConfig {
+	t.Helper()
+	t.Setenv(testEngineEnv, mode)
+	t.Setenv(testEngineMark, filepath.Join(t.TempDir(), "crashed"))
+	return ExternalEngineConfig{Name: "test", Command: []string{os.Args[0], "-test.run=^$"}}
+}
+
+func TestExternalEngineBestMove(t *testing.T) {
+	p := newEnginePool(testEngineConfig(t, "play"))
+	defer p.close()
+	pos := mustParse(t, StartPosition)
+	for i := 0; i < 2; i++ {
+		col, err := p.bestMove(pos, time.Second)
+		if err != nil || col != 3 {
+			t.Fatalf("search %d = %d, %v; want column 3", i+1, col, err)
+		}
+	}
+	if len(p.slots) != 1 {
+		t.Errorf("%d engine processes running, want the one reused", len(p.slots))
+	}
+}
+
+func TestExternalEngineTimeoutKills(t *testing.T) {
+	cfg := testEngineConfig(t, "hang")
+	pos := mustParse(t, StartPosition)
+
+	proc, err := startEngineProcess(cfg, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := proc.bestMove(pos, 50*time.Millisecond); !errors.Is(err, errEngineTimeout) {
+		t.Errorf("search of a hung engine = %v, want errEngineTimeout", err)
+	}
+	if took := time.Since(start); took < 50*time.Millisecond+engineGrace {
+		t.Errorf("gave up after %s, before movetime and grace had passed", took)
+	}
+	proc.kill()
+	select {
+	case <-proc.exited:
+	case <-time.After(2 * time.Second):
+		t.Error("hung engine still running after kill")
+	}
+
+	p := newEnginePool(cfg)
+	defer p.close()
+	if _, err := p.bestMove(pos, 50*time.Millisecond); !errors.Is(err, errEngineTimeout) {
+		t.Errorf("pool search of a hung engine = %v, want errEngineTimeout", err)
+	}
+	if len(p.slots) != 0 || len(p.idle) != 0 {
+		t.Errorf("hung engine kept in the pool: %d running, %d idle", len(p.slots), len(p.idle))
+	}
+}
+
+func TestExternalEngineRestartsAfterCrash(t *testing.T) {
+	p := newEnginePool(testEngineConfig(t, "crash-once"))
+	defer p.close()
+	col, err := p.bestMove(mustParse(t, StartPosition), 2*time.Second)
+	if err != nil || col != 3 {
+		t.Errorf("search with an engine that crashes once = %d, %v; want column 3 from the restarted engine", col, err)
+	}
+	if _, err := os.Stat(os.Getenv(testEngineMark)); err != nil {
+		t.Errorf("the first engine never crashed: %v", err)
+	}
+}
+
+func TestExternalEngineFallsBack(t *testing.T) {
+	p := newEnginePool(testEngineConfig(t, "crash"))
+	defer p.close()
+	e := &externalEngine{pool: p, fallback: heuristicEngine{}}
+	// x wins in column 4; the engine crashes twice and the heuristic plays
+	pos := mustParse(t, "7/7/7/o6/oo5/xxx4 x classic")
+	if col := e.ChooseMove(pos, time.Second); col != 3 {
+		t.Errorf("ChooseMove = %d, want the heuristic's winning column 3", col)
+	}
+	if _, err := p.bestMove(pos, time.Second); !errors.Is(err, errEngineExited) {
+		t.Errorf("search with an engine that always crashes = %v, want errEngineExited", err)
+	}
+}
+
+func TestEnginePoolAcquireAfterClose(t *testing.T) {
+	p := newEnginePool(ExternalEngineConfig{Name: "test", Command: []string{"false"}})
+	p.close()
+	if _, err := p.acquire(time.Now().Add(time.Second)); !errors.Is(err, errEngineClosed) {
+		t.Errorf("acquire on a closed pool = %v, want errEngineClosed", err)
+	}
+	p.close()
+}
+
+func TestEnginePoolCloseWakesWaiters(t *testing.T) {
+	p := newEnginePool(ExternalEngineConfig{Name: "test", Command: []string{"false"}, Processes: 1})
+	p.slots <- struct{}{} // the only process is busy
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := p.acquire(time.Now().Add(time.Minute))
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.close()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, errEngineClosed) {
+			t.Errorf("waiting acquire = %v, want errEngineClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acquire still waiting after close")
+	}
+}
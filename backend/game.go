@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -18,6 +19,29 @@ const (
 	P2    Player = 2
 )
 
+// Result is how a finished game ended. It is stored as the outcome of game
+// results instead of comparing usernames against magic strings.
+type Result string
+
+const (
+	ResultNone  Result = ""
+	ResultP1Win Result = "player1_win"
+	ResultP2Win Result = "player2_win"
+	ResultDraw  Result = "draw"
+)
+
+// VariantClassic is the standard 6x7 board. It is the only variant played so
+// far, but results record it so rankings can be split once there are others.
+const VariantClassic = "classic"
+
+// winFor returns the result of p winning
+func winFor(p Player) Result {
+	if p == P1 {
+		return ResultP1Win
+	}
+	return ResultP2Win
+}
+
 // GameLogic handles the in-memory state of a Connect Four game
 type GameLogic struct {
 	ID           string
@@ -25,11 +49,15 @@ type GameLogic struct {
 	Turn         Player
 	Player1      string
 	Player2      string
+	Player1ID    string
+	Player2ID    string
 	StartedAt    time.Time
 	Moves        int
 	Finished     bool
-	WinnerUser   string // username or "draw"
+	Result       Result
 	LastMoveTime time.Time
+	History      []int  // columns played, in order
+	Start        string // position the game started from, "" for the empty board
 }
 
 // NewGame initializes a new game
@@ -53,33 +81,24 @@ func (g *GameLogic) Drop(column int, username string) (row int, err error) {
 		return -1, errors.New("invalid column")
 	}
 
-	expected := g.CurrentPlayerName()
-	if username != expected && !(username == "BOT" && expected == g.Player2) {
+	if username != g.CurrentPlayerName() {
 		return -1, errors.New("not your turn")
 	}
 
 	for r := Rows - 1; r >= 0; r-- {
 		if g.Board[r][column] == Empty {
-			var mark Player
-			if username == g.Player1 {
-				mark = P1
-			} else {
-				mark = P2
-			}
-			if username == "BOT" && g.Player2 == "BOT" {
-				mark = P2
-			}
-
+			mark := g.Turn
 			g.Board[r][column] = mark
 			g.Moves++
+			g.History = append(g.History, column)
 			g.LastMoveTime = time.Now()
 
 			if g.checkWin(r, column, mark) {
 				g.Finished = true
-				g.WinnerUser = username
+				g.Result = winFor(mark)
 			} else if g.isFull() {
 				g.Finished = true
-				g.WinnerUser = "draw"
+				g.Result = ResultDraw
 			} else {
 				g.toggleTurn()
 			}
@@ -90,6 +109,58 @@ func (g *GameLogic) Drop(column int, username string) (row int, err error) {
 	return -1, errors.New("column full")
 }
 
+// Replay rebuilds a game from its move history by dropping each column for
+// whichever player is on turn. start is the position the game began from, or
+// "" for the empty board.
+func Replay(id, p1, p2, start string, history []int) (*GameLogic, error) {
+	g := NewGame(id, p1, p2)
+	if start != "" {
+		pos, err := ParsePosition(start)
+		if err != nil {
+			return nil, fmt.Errorf("start position: %w", err)
+		}
+		g = NewGameFrom(id, p1, p2, pos)
+	}
+	for _, col := range history {
+		if _, err := g.Drop(col, g.CurrentPlayerName()); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Forfeit ends the game with the other side winning
+func (g *GameLogic) Forfeit(loser Player) {
+	g.Finished = true
+	if loser == P1 {
+		g.Result = ResultP2Win
+	} else {
+		g.Result = ResultP1Win
+	}
+}
+
+// WinnerName returns the winner's username, or "" for a draw or a game in progress
+func (g *GameLogic) WinnerName() string {
+	switch g.Result {
+	case ResultP1Win:
+		return g.Player1
+	case ResultP2Win:
+		return g.Player2
+	}
+	return ""
+}
+
+// WinnerID returns the winner's player ID, or "" for a draw or a game in progress
+func (g *GameLogic) WinnerID() string {
+	switch g.Result {
+	case ResultP1Win:
+		return g.Player1ID
+	case ResultP2Win:
+		return g.Player2ID
+	}
+	return ""
+}
+
 // CurrentPlayerName returns the username of the player whose turn it is
 func (g *GameLogic) CurrentPlayerName() string {
 	if g.Turn == P1 {
@@ -119,6 +190,12 @@ func (g *GameLogic) isFull() bool {
 
 // checkWin checks if placing mark at (r, c) wins the game
 func (g *GameLogic) checkWin(r, c int, mark Player) bool {
+	return fourAt(&g.Board, r, c, mark)
+}
+
+// fourAt reports whether the disc at (r, c) is part of four or more of mark
+// in a row
+func fourAt(board *[Rows][Cols]Player, r, c int, mark Player) bool {
 	dirs := [][2]int{{0, 1}, {1, 0}, {1, 1}, {1, -1}}
 
 	for _, d := range dirs {
@@ -126,7 +203,7 @@ func (g *GameLogic) checkWin(r, c int, mark Player) bool {
 		for step := 1; step < 4; step++ {
 			rr := r + d[0]*step
 			cc := c + d[1]*step
-			if rr < 0 || rr >= Rows || cc < 0 || cc >= Cols || g.Board[rr][cc] != mark {
+			if rr < 0 || rr >= Rows || cc < 0 || cc >= Cols || board[rr][cc] != mark {
 				break
 			}
 			count++
@@ -134,7 +211,7 @@ func (g *GameLogic) checkWin(r, c int, mark Player) bool {
 		for step := 1; step < 4; step++ {
 			rr := r - d[0]*step
 			cc := c - d[1]*step
-			if rr < 0 || rr >= Rows || cc < 0 || cc >= Cols || g.Board[rr][cc] != mark {
+			if rr < 0 || rr >= Rows || cc < 0 || cc >= Cols || board[rr][cc] != mark {
 				break
 			}
 			count++
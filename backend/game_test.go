@@ -0,0 +1,81 @@
+package main
+
+import "testing"
+
+func gameAt(t *testing.T, s string) *GameLogic {
+	t.Helper()
+	pos, err := ParsePosition(s)
+	if err != nil {
+		t.Fatalf("ParsePosition(%q): %v", s, err)
+	}
+	return NewGameFrom("test", "alice", "bob", pos)
+}
+
+// boardOf builds a board from its rows, top first, with '.' for an empty
+// cell. Unlike ParsePosition it does not care whether the board is reachable.
+func boardOf(rows ...string) [Rows][Cols]Player {
+	var b [Rows][Cols]Player
+	for r, row := range rows {
+		for c, ch := range row {
+			switch ch {
+			case 'x':
+				b[r][c] = P1
+			case 'o':
+				b[r][c] = P2
+			}
+		}
+	}
+	return b
+}
+
+func TestCheckWin(t *testing.T) {
+	empty := "......."
+	tests := []struct {
+		name  string
+		board [Rows][Cols]Player
+		r, c  int
+		mark  Player
+		want  bool
+	}{
+		{"horizontal", boardOf(empty, empty, empty, empty, empty, "xxxx..."), 5, 3, P1, true},
+		{"horizontal from the middle", boardOf(empty, empty, empty, empty, empty, "..oooo."), 5, 3, P2, true},
+		{"horizontal three", boardOf(empty, empty, empty, empty, empty, "xxx.x.."), 5, 2, P1, false},
+		{"vertical", boardOf(empty, empty, "x......", "x......", "x......", "x......"), 2, 0, P1, true},
+		{"vertical three", boardOf(empty, empty, empty, "x......", "x......", "x......"), 3, 0, P1, false},
+		{"diagonal up right", boardOf(empty, empty, "...x...", "..x....", ".x.....", "x......"), 2, 3, P1, true},
+		{"diagonal down right", boardOf(empty, empty, "...o...", "....o..", ".....o.", "......o"), 4, 5, P2, true},
+		{"broken diagonal", boardOf(empty, empty, "...x...", "..o....", ".x.....", "x......"), 2, 3, P1, false},
+		{"other player's line", boardOf(empty, empty, empty, empty, empty, "xxxx..."), 5, 3, P2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := &GameLogic{Board: tt.board}
+			if got := g.checkWin(tt.r, tt.c, tt.mark); got != tt.want {
+				t.Errorf("checkWin(%d, %d, %d) = %v, want %v", tt.r, tt.c, tt.mark, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBotChooseColumn(t *testing.T) {
+	tests := []struct {
+		name string
+		pos  string
+		bot  string
+		want int
+	}{
+		{"takes a horizontal win", "7/7/7/7/xxx4/ooox3 o classic", "bob", 3},
+		{"takes a vertical win", "7/7/7/6o/x5o/xxx3o o classic", "bob", 6},
+		{"wins rather than blocks", "7/7/7/o6/o6/oxxx3 x classic", "alice", 4},
+		{"blocks a horizontal four", "7/7/7/7/6o/1xxx2o o classic", "bob", 0},
+		{"blocks a vertical four", "7/7/7/x6/x6/xoo4 o classic", "bob", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := gameAt(t, tt.pos)
+			if got := g.BotChooseColumn(tt.bot); got != tt.want {
+				t.Errorf("BotChooseColumn(%q) = %d, want %d", tt.bot, got, tt.want)
+			}
+		})
+	}
+}
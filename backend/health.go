@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+	"time"
+)
+
+// errKafkaUnavailable is reported when brokers are configured but the
+// producer could not be created at startup
+var errKafkaUnavailable = errors.New("brokers configured but producer not connected")
+
+// registerHealthRoutes mounts the liveness, readiness and diagnostics
+// endpoints. brokers is the configured Kafka broker list; kafka is nil when
+// events are disabled or the producer failed to start.
+func registerHealthRoutes(mux *http.ServeMux, cfg DebugConfig, hub *Hub, db *MongoDB, brokers []string, kafka *KafkaProducer) {
+	// Liveness only says the process is serving HTTP
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		checks := map[string]string{}
+		ready := true
+		check := func(name string, err error) {
+			if err != nil {
+				checks[name] = err.Error()
+				ready = false
+				return
+			}
+			checks[name] = "ok"
+		}
+
+		check("mongo", db.Client.Ping(ctx, nil))
+		switch {
+		case kafka != nil:
+			check("kafka", kafka.Ping(ctx))
+		case len(brokers) > 0:
+			check("kafka", errKafkaUnavailable)
+		}
+		var hubErr error
+		if !hub.Accepting() {
+			hubErr = errShuttingDown
+		}
+		check("hub", hubErr)
+
+		status := http.StatusOK
+		if !ready {
+			requestLogger(r, "readyz").Warn("not ready", "checks", checks)
+			status = http.StatusServiceUnavailable
+		}
+		writeJSONStatus(w, status, map[string]interface{}{"ready": ready, "checks": checks})
+	})
+
+	if cfg.Token == "" {
+		return
+	}
+	mux.HandleFunc("/debug/state", requireDebugToken(cfg.Token, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, hub.Snapshot())
+	}))
+	if cfg.Pprof {
+		mux.HandleFunc("/debug/pprof/", requireDebugToken(cfg.Token, pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", requireDebugToken(cfg.Token, pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", requireDebugToken(cfg.Token, pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", requireDebugToken(cfg.Token, pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", requireDebugToken(cfg.Token, pprof.Trace))
+	}
+}
+
+// requireDebugToken rejects requests without "Authorization: Bearer <token>"
+func requireDebugToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			requestLogger(r, "debug").Warn("rejected debug request", "path", r.URL.Path)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "fmt"
+
+// Hint is a suggested move for the side to move, with the reason in words a
+// new player can follow
+type Hint struct {
+	Column int    `json:"column"` // 0-based
+	Reason string `json:"reason"`
+}
+
+// SuggestMove picks a move for the side to move in pos. Immediate wins and
+// blocks come first; otherwise the engine's best move at depth plies is
+// explained by what it sets up. ok is false when the game is over.
+func SuggestMove(pos Position, depth int) (h Hint, ok bool) {
+	if pos.Finished {
+		return Hint{}, false
+	}
+	b := newSearchBoard(pos)
+	if wins := b.winningColumns(); len(wins) > 0 {
+		return Hint{Column: wins[0], Reason: "wins immediately"}, true
+	}
+	b.turn = opponent(b.turn)
+	threats := b.winningColumns()
+	b.turn = opponent(b.turn)
+	if len(threats) > 0 {
+		return Hint{Column: threats[0], Reason: "blocks opponent's four"}, true
+	}
+
+	col, score, ok := BestMove(ScoreMoves(pos, depth))
+	if !ok {
+		return Hint{}, false
+	}
+	h.Column = col
+	// Two threats at once can only be answered one at a time, unless the
+	// opponent has a win of their own to play first
+	b.play(col)
+	replies := b.winningColumns()
+	b.turn = opponent(b.turn)
+	double := len(b.winningColumns()) >= 2 && len(replies) == 0
+	b.turn = opponent(b.turn)
+	b.undo(col)
+
+	switch {
+	case double:
+		h.Reason = "sets up a double threat"
+	case IsForcedWin(score):
+		h.Reason = fmt.Sprintf("forces a win in %d moves", (WinScore-score+1)/2)
+	case IsForcedLoss(score):
+		h.Reason = "holds out longest"
+	default:
+		h.Reason = "strongest move the engine found"
+	}
+	return h, true
+}
+
+// winningColumns returns the columns where the side to move completes four
+func (b *searchBoard) winningColumns() []int {
+	var cols []int
+	for _, c := range searchOrder {
+		if b.canPlay(c) && b.wins(c) {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
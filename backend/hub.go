@@ -3,28 +3,76 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type WSClient struct {
 	Conn     *websocket.Conn
 	Username string
+	PlayerID string // user ID, or the guest name for guests
 	Send     chan []byte
 	GameID   string
+	Addr     string
+	Bot      bool   // a bot account, connected with an API key
+	Queue    string // who it will be matched with, see canMatch
+	released bool   // Send is closed, see releaseClient; guarded by Hub.mu
+	log      *slog.Logger
+}
+
+// Queues a player can join. By default people only meet people and bot
+// accounts meet anyone who accepts bots; QueueAny lets a person opt in to
+// bot accounts and QueueBots matches with bot accounts only.
+const (
+	QueueDefault = ""
+	QueueAny     = "any"
+	QueueBots    = "bots"
+)
+
+// accepts reports whether c is willing to play other
+func (c *WSClient) accepts(other *WSClient) bool {
+	switch c.Queue {
+	case QueueBots:
+		return other.Bot
+	case QueueAny:
+		return true
+	}
+	return c.Bot || !other.Bot
+}
+
+// canMatch reports whether a and b may be paired
+func canMatch(a, b *WSClient) bool {
+	return a.PlayerID != b.PlayerID && a.accepts(b) && b.accepts(a)
 }
 
 type Hub struct {
-	mu      sync.Mutex
-	waiting []*WSClient
-	games   map[string]*GameInstance
-	db      *MongoDB
+	mu        sync.Mutex
+	waiting   []*WSClient
+	games     map[string]*GameInstance
+	resuming  map[string]*GameInstance // suspended games waiting for their players to rejoin
+	clients   map[*WSClient]struct{}
+	sessions  map[string][]*WSClient // open sockets by player ID
+	suspended map[string]bool        // players with a suspended game; nil until LoadSuspended, when everyone is looked up
+	closing   bool
+	cfg       GameConfig
+	db        *MongoDB
+	kafka     *KafkaProducer
+	metrics   Metrics
+	book      *OpeningBook // nil when bots play without one
+	clock     Clock        // paces the bots and hint cooldowns
+	rng       *rand.Rand   // seeds every bot game
+	log       *slog.Logger
 }
 
 type GameInstance struct {
@@ -32,82 +80,240 @@ type GameInstance struct {
 	P1        *WSClient
 	P2        *WSClient
 	CreatedAt time.Time
+	Bot       BotEngine    // nil unless a server bot plays
+	Persona   *Personality // set along with Bot
+	VsBot     bool         // a server bot or a bot account plays
+	Hints     int          // hints given so far; hinted games stay out of player stats
+	lastHint  time.Time
+	expiry    *time.Timer // aborts a suspended game if the opponent does not return
+	log       *slog.Logger
 }
 
+var errShuttingDown = errors.New("server is shutting down")
+
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool { return true },
 }
 
-func NewHub(db *MongoDB) *Hub {
+func NewHub(cfg GameConfig, db *MongoDB, kafka *KafkaProducer, metrics Metrics, book *OpeningBook) *Hub {
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
 	return &Hub{
-		waiting: []*WSClient{},
-		games:   make(map[string]*GameInstance),
-		db:      db,
+		waiting:  []*WSClient{},
+		games:    make(map[string]*GameInstance),
+		resuming: make(map[string]*GameInstance),
+		clients:  make(map[*WSClient]struct{}),
+		sessions: make(map[string][]*WSClient),
+		cfg:      cfg,
+		db:       db,
+		kafka:    kafka,
+		metrics:  metrics,
+		book:     book,
+		clock:    realClock{},
+		rng:      rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		log:      slog.Default().With("component", "hub"),
 	}
 }
 
+// Rated reports whether the game counts towards ratings and leaderboards
+func (inst *GameInstance) Rated() bool {
+	return !isGuestName(inst.Game.Player1) && !isGuestName(inst.Game.Player2) && inst.Hints == 0
+}
+
+// updateGauges publishes the current hub sizes. Caller must hold h.mu.
+func (h *Hub) updateGauges() {
+	h.metrics.SetHubState(len(h.clients), len(h.waiting), len(h.games))
+}
+
 type WSMessage struct {
 	Type     string      `json:"type"`
 	Username string      `json:"username,omitempty"`
 	Column   int         `json:"column,omitempty"`
 	GameID   string      `json:"gameId,omitempty"`
+	Position string      `json:"position,omitempty"` // join only: play the bot from this position
+	Bot      string      `json:"bot,omitempty"`      // join only: play this bot engine right away
+	Queue    string      `json:"queue,omitempty"`    // join only: who to be matched with, see QueueAny
 	Payload  interface{} `json:"payload,omitempty"`
 }
 
 // ServeWS handles new WebSocket connections
 func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	closing := h.closing
+	h.mu.Unlock()
+	reqLog := h.log.With("op", "ws_join", "remote_addr", r.RemoteAddr)
+	if closing {
+		reqLog.Info("rejected connection during shutdown")
+		http.Error(w, errShuttingDown.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		reqLog.Warn("websocket upgrade failed", "error", err)
 		http.Error(w, "Could not open ws", http.StatusBadRequest)
 		return
 	}
 
 	_, msg, err := conn.ReadMessage()
 	if err != nil {
+		reqLog.Warn("failed to read join message", "error", err)
 		conn.Close()
 		return
 	}
 
 	var m WSMessage
-	json.Unmarshal(msg, &m)
-	if m.Type != "join" || m.Username == "" {
+	if err := json.Unmarshal(msg, &m); err != nil {
+		reqLog.Warn("malformed join message", "error", err)
+	}
+	if m.Type != "join" {
+		reqLog.Warn("first message was not a join", "type", m.Type)
+		conn.Close()
+		return
+	}
+	var start *Position
+	if m.Position != "" {
+		pos, err := ParsePosition(m.Position)
+		if err == nil && pos.Finished {
+			err = errors.New("position is already decided")
+		}
+		if err != nil {
+			reqLog.Info("rejected start position", "position", m.Position, "error", err)
+			conn.WriteJSON(WSMessage{Type: "error", Payload: "invalid position: " + err.Error()})
+			conn.Close()
+			return
+		}
+		start = &pos
+	}
+	switch m.Queue {
+	case QueueDefault, QueueAny, QueueBots:
+	default:
+		reqLog.Info("rejected queue", "queue", m.Queue)
+		conn.WriteJSON(WSMessage{Type: "error", Payload: "unknown queue " + m.Queue + ", choose any or bots"})
+		conn.Close()
+		return
+	}
+	if m.Bot != "" {
+		if _, ok := botEngines[m.Bot]; !ok {
+			reqLog.Info("rejected bot engine", "bot", m.Bot)
+			conn.WriteJSON(WSMessage{Type: "error", Payload: "unknown bot " + m.Bot + ", choose one of " + strings.Join(BotEngineNames(), ", ")})
+			conn.Close()
+			return
+		}
+	}
+
+	// Registered users play under the name in their token. Guests keep
+	// the name they asked for, namespaced so it can never be rated.
+	var username, playerID string
+	var bot bool
+	if id, ok := identityFrom(r.Context()); ok && !id.Guest {
+		username, playerID, bot = id.Username, id.UserID, id.Bot
+	} else {
+		username, err = guestName(m.Username)
+		if err != nil {
+			reqLog.Info("rejected guest name", "requested", m.Username, "error", err)
+			conn.WriteJSON(WSMessage{Type: "error", Payload: err.Error()})
+			conn.Close()
+			return
+		}
+		playerID = username
+	}
+
+	client := &WSClient{
+		Conn:     conn,
+		Username: username,
+		PlayerID: playerID,
+		Send:     make(chan []byte, h.cfg.SendBuffer),
+		Addr:     r.RemoteAddr,
+		Bot:      bot,
+		Queue:    m.Queue,
+		log:      h.log.With("username", username, "remote_addr", r.RemoteAddr),
+	}
+	h.mu.Lock()
+	tookOver, err := h.claimSession(client)
+	h.updateGauges()
+	h.mu.Unlock()
+	if err != nil {
+		client.log.Info("rejected duplicate session", "op", "ws_join", "error", err)
+		conn.WriteJSON(WSMessage{Type: "error", Payload: err.Error()})
 		conn.Close()
 		return
 	}
+	client.log.Info("player joined", "op", "ws_join", "took_over", tookOver)
 
-	client := &WSClient{Conn: conn, Username: m.Username, Send: make(chan []byte, 256)}
 	go h.writer(client)
 	go h.reader(client)
-	h.addToQueue(client)
+	if tookOver {
+		return
+	}
+	if start != nil || m.Bot != "" {
+		engine := m.Bot
+		if engine == "" {
+			engine = h.cfg.BotEngine
+		}
+		h.mu.Lock()
+		if !h.closing && !client.released {
+			h.startBotGame(client, engine, start)
+			h.updateGauges()
+		}
+		h.mu.Unlock()
+		return
+	}
+	if !h.resume(client) {
+		h.addToQueue(client)
+	}
 }
 
 func (h *Hub) addToQueue(c *WSClient) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	defer h.updateGauges()
 
-	if len(h.waiting) > 0 {
-		other := h.waiting[0]
-		h.waiting = h.waiting[1:]
+	// A client that disconnected while joining is gone for good
+	if h.closing || c.released {
+		return
+	}
+
+	// Match with the longest waiting player who is not this same user and
+	// is willing to play them
+	opponent := -1
+	for i, w := range h.waiting {
+		if canMatch(w, c) {
+			opponent = i
+			break
+		}
+	}
+	if opponent >= 0 {
+		other := h.waiting[opponent]
+		h.waiting = append(h.waiting[:opponent], h.waiting[opponent+1:]...)
 
 		gameID := uuid.NewString()
 		g := NewGame(gameID, other.Username, c.Username)
-		inst := &GameInstance{Game: g, P1: other, P2: c, CreatedAt: time.Now()}
+		g.Player1ID, g.Player2ID = other.PlayerID, c.PlayerID
+		vsBot := other.Bot || c.Bot
+		inst := &GameInstance{Game: g, P1: other, P2: c, VsBot: vsBot, CreatedAt: time.Now(), log: h.log.With("game_id", gameID)}
 		other.GameID = gameID
 		c.GameID = gameID
 		h.games[gameID] = inst
 
 		if h.db != nil {
 			coll := h.db.Database.Collection("games")
+			now := time.Now()
 			_, err := coll.InsertOne(context.TODO(), GameDB{
 				GameID:    gameID,
 				Player1:   other.Username,
 				Player2:   c.Username,
-				StartedAt: time.Now(),
+				Player1ID: other.PlayerID,
+				Player2ID: c.PlayerID,
+				StartedAt: now,
 				Finished:  false,
-				CreatedAt: time.Now(),
+				VsBot:     vsBot,
+				CreatedAt: now,
+				UpdatedAt: now,
 			})
 			if err != nil {
-				log.Println("Mongo insert error:", err)
+				inst.log.Error("failed to store new game", "op", "match", "error", err)
 			}
 		}
 
@@ -121,69 +327,146 @@ func (h *Hub) addToQueue(c *WSClient) {
 		}
 		h.sendJSON(other, startMsg)
 		h.sendJSON(c, startMsg)
+		h.kafka.Publish("game_start", startMsg)
+		h.metrics.GameStarted(vsBot)
+		inst.log.Info("game started", "op", "match", "player1", other.Username, "player2", c.Username, "vs_bot", vsBot)
 		return
 	}
 
 	h.waiting = append(h.waiting, c)
+	c.log.Info("waiting for opponent", "op", "queue", "queue_length", len(h.waiting))
+
+	h.scheduleBotFallback(c)
+}
 
-	// Start bot game if no opponent joins in 10s
-	go func(username string, client *WSClient) {
-		time.Sleep(10 * time.Second)
+// scheduleBotFallback starts a bot game for c if it is still waiting once
+// the fallback delay has passed. Bot accounts keep waiting instead.
+func (h *Hub) scheduleBotFallback(client *WSClient) {
+	if client.Bot {
+		return
+	}
+	go func() {
+		time.Sleep(h.cfg.BotFallback)
 		h.mu.Lock()
 		defer h.mu.Unlock()
+		defer h.updateGauges()
+
+		if h.closing {
+			return
+		}
 
 		for i, w := range h.waiting {
-			if w.Username == username {
+			if w == client {
 				h.waiting = append(h.waiting[:i], h.waiting[i+1:]...)
-				gameID := uuid.NewString()
-				botName := "BOT"
-				g := NewGame(gameID, username, botName)
-				inst := &GameInstance{Game: g, P1: client, P2: nil, CreatedAt: time.Now()}
-				client.GameID = gameID
-				h.games[gameID] = inst
-
-				if h.db != nil {
-					coll := h.db.Database.Collection("games")
-					_, err := coll.InsertOne(context.TODO(), GameDB{
-						GameID:    gameID,
-						Player1:   username,
-						Player2:   botName,
-						StartedAt: time.Now(),
-						Finished:  false,
-						CreatedAt: time.Now(),
-					})
-					if err != nil {
-						log.Println("Mongo insert error:", err)
-					}
-				}
-
-				startMsg := WSMessage{
-					Type:   "start",
-					GameID: gameID,
-					Payload: map[string]interface{}{
-						"player1": username,
-						"player2": botName,
-					},
-				}
-				h.sendJSON(client, startMsg)
-				go h.botLoop(inst)
+				h.startBotGame(client, h.cfg.BotEngine, nil)
 				break
 			}
 		}
-	}(c.Username, c)
+	}()
+}
+
+// newBot returns a fresh engine by name, in the style of its personality
+// and opening from the book if there is one, along with the personality.
+// The random engine stays out of the book; it is meant to play badly.
+// Caller must hold h.mu.
+func (h *Hub) newBot(engine string) (BotEngine, *Personality, bool) {
+	bot, ok := NewSeededBotEngine(engine, h.rng.Uint64())
+	if !ok {
+		return nil, nil, false
+	}
+	cfg := h.cfg.BotPersonalities[engine]
+	if styled, ok := bot.(styledEngine); ok {
+		bot = styled.WithStyle(cfg.Style())
+	}
+	if engine != "random" {
+		bot = WithOpeningBook(bot, h.book, h.cfg.BookPlies, rand.New(rand.NewPCG(h.rng.Uint64(), h.rng.Uint64())))
+	}
+	persona := NewPersonality(cfg, h.cfg.BotMoveDelay, h.clock, rand.New(rand.NewPCG(h.rng.Uint64(), h.rng.Uint64())))
+	return bot, persona, true
 }
 
+// startBotGame starts a game between client and a bot playing engine, which
+// must be registered, from start if set. Games from a set position are
+// practice: they are stored so they can be replayed and resumed, but record
+// no result. Caller must hold h.mu.
+func (h *Hub) startBotGame(client *WSClient, engine string, start *Position) {
+	bot, persona, _ := h.newBot(engine)
+	botName := BotPlayerName(engine)
+	gameID := uuid.NewString()
+	g := NewGame(gameID, client.Username, botName)
+	if start != nil {
+		g = NewGameFrom(gameID, client.Username, botName, *start)
+	}
+	g.Player1ID, g.Player2ID = client.PlayerID, botName
+	inst := &GameInstance{Game: g, P1: client, P2: nil, Bot: bot, Persona: persona, VsBot: true, CreatedAt: time.Now(), log: h.log.With("game_id", gameID)}
+	client.GameID = gameID
+	h.games[gameID] = inst
+
+	if h.db != nil {
+		coll := h.db.Database.Collection("games")
+		now := time.Now()
+		_, err := coll.InsertOne(context.TODO(), GameDB{
+			GameID:    gameID,
+			Player1:   client.Username,
+			Player2:   botName,
+			Player1ID: client.PlayerID,
+			Player2ID: botName,
+			StartedAt: now,
+			Finished:  false,
+			Start:     g.Start,
+			BotEngine: engine,
+			VsBot:     true,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			inst.log.Error("failed to store new game", "op", "bot_match", "error", err)
+		}
+	}
+
+	payload := map[string]interface{}{
+		"player1": client.Username,
+		"player2": botName,
+	}
+	if start != nil {
+		payload["position"] = g.Start
+		payload["board"] = g.Board
+		payload["turn"] = g.CurrentPlayerName()
+	}
+	startMsg := WSMessage{Type: "start", GameID: gameID, Payload: payload}
+	h.sendJSON(client, startMsg)
+	h.kafka.Publish("game_start", startMsg)
+	h.metrics.GameStarted(true)
+	inst.log.Info("bot game started", "op", "bot_match", "player1", client.Username, "bot", engine, "position", g.Start)
+	h.sendEmote(inst, persona.Emote(EmoteStart))
+	go h.botLoop(inst)
+}
+
+// sendJSON queues m for client's writer, dropping it when the buffer is full
+// or the client has been released. Caller must hold h.mu.
 func (h *Hub) sendJSON(client *WSClient, m WSMessage) {
-	b, _ := json.Marshal(m)
+	if client.released {
+		return
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		client.log.Error("failed to encode message", "op", "send", "type", m.Type, "error", err)
+		return
+	}
 	select {
 	case client.Send <- b:
 	default:
+		h.metrics.SendDropped()
+		client.log.Warn("send buffer full, message dropped", "op", "send", "type", m.Type, "game_id", m.GameID)
 	}
 }
 
+// writer sends client's queued messages until releaseClient closes Send
 func (h *Hub) writer(client *WSClient) {
 	for msg := range client.Send {
-		client.Conn.WriteMessage(websocket.TextMessage, msg)
+		if err := client.Conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			client.log.Warn("websocket write failed", "op", "write", "error", err)
+		}
 	}
 }
 
@@ -192,13 +475,22 @@ func (h *Hub) reader(client *WSClient) {
 	for {
 		_, data, err := client.Conn.ReadMessage()
 		if err != nil {
+			client.log.Info("connection closed", "op", "read", "error", err)
 			h.handleDisconnect(client)
 			break
 		}
 		var m WSMessage
-		json.Unmarshal(data, &m)
-		if m.Type == "drop" {
+		if err := json.Unmarshal(data, &m); err != nil {
+			client.log.Warn("malformed message", "op", "read", "error", err)
+			continue
+		}
+		switch m.Type {
+		case "drop":
+			start := time.Now()
 			h.handleDrop(client, m.Column)
+			h.metrics.MoveProcessed(time.Since(start))
+		case "hint":
+			h.handleHint(client)
 		}
 	}
 }
@@ -214,6 +506,7 @@ func (h *Hub) handleDrop(client *WSClient, col int) {
 
 	_, err := inst.Game.Drop(col, client.Username)
 	if err != nil {
+		inst.log.Info("rejected move", "op", "drop", "username", client.Username, "column", col, "error", err)
 		h.sendJSON(client, WSMessage{Type: "error", Payload: err.Error()})
 		return
 	}
@@ -229,67 +522,208 @@ func (h *Hub) handleDrop(client *WSClient, col int) {
 	if inst.P2 != nil {
 		h.sendJSON(inst.P2, moveMsg)
 	}
+	h.kafka.Publish("move", moveMsg)
 
 	if inst.Game.Finished {
-		h.finishGame(inst)
+		h.finishGame(inst, false)
 	} else if inst.P2 == nil {
 		go h.botLoop(inst)
 	}
 }
 
-func (h *Hub) finishGame(inst *GameInstance) {
-	resMsg := WSMessage{Type: "end", GameID: inst.Game.ID, Payload: map[string]interface{}{
-		"winner": inst.Game.WinnerUser,
-	}}
+// handleHint suggests a move to a player on turn in a bot game, within the
+// per game limit and cooldown. Like botLoop it searches without h.mu held
+// and checks the game has not moved on before replying.
+func (h *Hub) handleHint(client *WSClient) {
+	h.mu.Lock()
+	inst, ok := h.games[client.GameID]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	if err := h.hintAllowed(inst, client); err != nil {
+		h.sendJSON(client, WSMessage{Type: "error", Payload: err.Error()})
+		h.mu.Unlock()
+		return
+	}
+	// Taking the cooldown now keeps a second request from starting another
+	// search while this one runs
+	inst.lastHint = h.clock.Now()
+	pos, moves := inst.Game.Position(), inst.Game.Moves
+	h.mu.Unlock()
+
+	hint, ok := SuggestMove(pos, h.cfg.HintDepth)
+	if !ok {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.games[inst.Game.ID] != inst || inst.Game.Finished || inst.Game.Moves != moves ||
+		inst.Hints >= h.cfg.HintLimit {
+		return
+	}
+	inst.Hints++
+	inst.log.Info("hint given", "op", "hint", "username", client.Username, "column", hint.Column, "hints", inst.Hints)
+	if h.db != nil {
+		_, err := h.db.Database.Collection("games").UpdateOne(context.TODO(),
+			bson.M{"game_id": inst.Game.ID}, bson.M{"$set": bson.M{"hints": inst.Hints}})
+		if err != nil {
+			inst.log.Error("failed to record hint", "op", "hint", "error", err)
+		}
+	}
+	h.sendJSON(client, WSMessage{Type: "hint", GameID: inst.Game.ID, Payload: map[string]interface{}{
+		"column":     hint.Column,
+		"reason":     hint.Reason,
+		"hints_left": h.cfg.HintLimit - inst.Hints,
+	}})
+}
+
+// hintAllowed returns why client may not have a hint in inst now, or nil.
+// Caller must hold h.mu.
+func (h *Hub) hintAllowed(inst *GameInstance, client *WSClient) error {
+	switch {
+	case inst.Bot == nil:
+		return errors.New("hints are only available against the bot")
+	case inst.Game.Finished || inst.Game.CurrentPlayerName() != client.Username:
+		return errors.New("not your turn")
+	case inst.Hints >= h.cfg.HintLimit:
+		return errors.New("no hints left in this game")
+	case h.clock.Now().Sub(inst.lastHint) < h.cfg.HintCooldown:
+		return errors.New("wait a little before asking for another hint")
+	}
+	return nil
+}
+
+// finishGame announces and stores the result of a finished game. forfeit
+// is set when the game ended because a player left. Caller must hold h.mu.
+func (h *Hub) finishGame(inst *GameInstance, forfeit bool) {
+	g := inst.Game
+	winner := g.WinnerName()
+	if g.Result == ResultDraw {
+		winner = "draw" // the wire protocol has always reported draws this way
+	}
+	payload := map[string]interface{}{
+		"winner":  winner,
+		"outcome": g.Result,
+	}
+	if forfeit {
+		payload["forfeit"] = true
+	}
+	resMsg := WSMessage{Type: "end", GameID: g.ID, Payload: payload}
 	if inst.P1 != nil {
 		h.sendJSON(inst.P1, resMsg)
 	}
 	if inst.P2 != nil {
 		h.sendJSON(inst.P2, resMsg)
 	}
+	h.kafka.Publish("game_end", resMsg)
+	if inst.Persona != nil {
+		event := EmoteLoss
+		switch g.WinnerName() {
+		case g.Player2:
+			event = EmoteWin
+		case "":
+			event = EmoteDraw
+		}
+		h.sendEmote(inst, inst.Persona.Emote(event))
+	}
 
-	if h.db != nil {
+	outcome := OutcomeWin
+	switch {
+	case forfeit:
+		outcome = OutcomeForfeit
+	case g.Result == ResultDraw:
+		outcome = OutcomeDraw
+	}
+	h.metrics.GameFinished(outcome, inst.VsBot)
+	inst.log.Info("game finished", "op", "finish", "outcome", g.Result, "winner", g.WinnerName(), "forfeit", forfeit, "moves", g.Moves)
+
+	// Practice games from a set position say nothing about anyone's
+	// strength, so only the games collection hears about them
+	if h.db != nil && g.Start == "" {
 		resColl := h.db.Database.Collection("game_results")
-		gameColl := h.db.Database.Collection("games")
 
-		_, err := resColl.InsertOne(context.TODO(), GameResult{
-			GameID:    inst.Game.ID,
-			Player1:   inst.Game.Player1,
-			Player2:   inst.Game.Player2,
-			Winner:    inst.Game.WinnerUser,
-			Moves:     inst.Game.Moves,
-			Duration:  time.Since(inst.Game.StartedAt),
+		res := GameResult{
+			GameID:    g.ID,
+			Player1:   g.Player1,
+			Player2:   g.Player2,
+			Player1ID: g.Player1ID,
+			Player2ID: g.Player2ID,
+			Winner:    g.WinnerName(),
+			WinnerID:  g.WinnerID(),
+			Outcome:   g.Result,
+			Forfeit:   forfeit,
+			Moves:     g.Moves,
+			Duration:  time.Since(g.StartedAt),
+			Rated:     inst.Rated(),
+			Hints:     inst.Hints,
+			BotEngine: botEngine(inst),
+			VsBot:     inst.VsBot,
+			Variant:   VariantClassic,
 			CreatedAt: time.Now(),
-		})
+		}
+		_, err := resColl.InsertOne(context.TODO(), res)
 		if err != nil {
-			log.Println("Error inserting game result:", err)
+			inst.log.Error("failed to store game result", "op", "finish", "error", err)
+		} else {
+			if err := h.db.RecordPlayerStats(context.TODO(), res); err != nil {
+				inst.log.Error("failed to update player stats", "op", "finish", "error", err)
+			}
+			if err := h.db.RecordGameTotals(context.TODO(), res); err != nil {
+				inst.log.Error("failed to update game totals", "op", "finish", "error", err)
+			}
 		}
+	}
+	if h.db != nil {
+		gameColl := h.db.Database.Collection("games")
 
-		_, err = gameColl.UpdateOne(
+		_, err := gameColl.UpdateOne(
 			context.TODO(),
-			bson.M{"game_id": inst.Game.ID},
+			bson.M{"game_id": g.ID},
 			bson.M{"$set": bson.M{
 				"finished":   true,
-				"winner":     inst.Game.WinnerUser,
+				"winner":     g.WinnerName(),
+				"outcome":    g.Result,
+				"move_log":   g.History,
 				"updated_at": time.Now(),
 			}},
 		)
 		if err != nil {
-			log.Println("Error updating game:", err)
+			inst.log.Error("failed to mark game finished", "op", "finish", "error", err)
 		}
 	}
 
-	delete(h.games, inst.Game.ID)
+	delete(h.games, g.ID)
+	h.updateGauges()
 }
 
+// botLoop plays the bot's move, taking as long as its personality likes.
+// The engine thinks without holding h.mu, so the move is only played if the
+// game is still where it was left.
 func (h *Hub) botLoop(inst *GameInstance) {
-	time.Sleep(350 * time.Millisecond)
-	if inst.Game.Finished {
+	h.mu.Lock()
+	if !h.botToMove(inst) {
+		h.mu.Unlock()
+		return
+	}
+	pos, moves := inst.Game.Position(), inst.Game.Moves
+	h.mu.Unlock()
+
+	start := time.Now()
+	col, emote := inst.Persona.Move(inst.Bot, pos, h.cfg.BotBudget)
+	inst.log.Debug("bot chose move", "op", "bot_move", "bot", inst.Bot.Name(), "column", col, "took", time.Since(start).String())
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.botToMove(inst) || inst.Game.Moves != moves {
+		return
+	}
+	botName := inst.Game.CurrentPlayerName()
+	if _, err := inst.Game.Drop(col, botName); err != nil {
+		inst.log.Error("bot played an illegal move", "op", "bot_move", "bot", inst.Bot.Name(), "column", col, "error", err)
 		return
 	}
-	botName := "BOT"
-	col := inst.Game.BotChooseColumn(botName)
-	inst.Game.Drop(col, botName)
 
 	moveMsg := WSMessage{Type: "move", GameID: inst.Game.ID, Payload: map[string]interface{}{
 		"player": botName,
@@ -299,16 +733,51 @@ func (h *Hub) botLoop(inst *GameInstance) {
 	if inst.P1 != nil {
 		h.sendJSON(inst.P1, moveMsg)
 	}
+	h.kafka.Publish("move", moveMsg)
+	h.sendEmote(inst, emote)
 
 	if inst.Game.Finished {
-		h.finishGame(inst)
+		h.finishGame(inst, false)
+	}
+}
+
+// sendEmote passes a line from the bot in inst to the player; "" sends
+// nothing. Caller must hold h.mu.
+func (h *Hub) sendEmote(inst *GameInstance, text string) {
+	if text == "" || inst.P1 == nil {
+		return
 	}
+	h.sendJSON(inst.P1, WSMessage{Type: "emote", GameID: inst.Game.ID, Payload: map[string]interface{}{
+		"player": inst.Game.Player2,
+		"text":   text,
+	}})
+}
+
+// botToMove reports whether inst is still live with its bot on turn; it may
+// have been suspended or forfeited meanwhile, and a game from a set position
+// can start with the player to move. Caller must hold h.mu.
+func (h *Hub) botToMove(inst *GameInstance) bool {
+	return inst.Bot != nil && !inst.Game.Finished && h.games[inst.Game.ID] == inst &&
+		isBotName(inst.Game.CurrentPlayerName())
+}
+
+// botEngine returns the name of the engine playing in inst, or "" when two
+// people play
+func botEngine(inst *GameInstance) string {
+	if inst.Bot == nil {
+		return ""
+	}
+	return inst.Bot.Name()
 }
 
 func (h *Hub) handleDisconnect(c *WSClient) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	defer h.updateGauges()
+	// Deferred until c is out of the queue and its game
+	defer h.releaseClient(c)
 
+	h.releaseSession(c)
 	for i, w := range h.waiting {
 		if w == c {
 			h.waiting = append(h.waiting[:i], h.waiting[i+1:]...)
@@ -320,66 +789,414 @@ func (h *Hub) handleDisconnect(c *WSClient) {
 	if gameID == "" {
 		return
 	}
+	if inst, ok := h.resuming[gameID]; ok {
+		// Leave the game suspended so the player can come back to it later
+		if inst.P1 == c {
+			inst.P1 = nil
+		}
+		if inst.P2 == c {
+			inst.P2 = nil
+		}
+		if inst.P1 == nil && inst.P2 == nil {
+			delete(h.resuming, gameID)
+		}
+		return
+	}
 	inst, ok := h.games[gameID]
 	if !ok || inst.Game.Finished {
 		return
 	}
 
-	var other string
-	if inst.P1 != nil && inst.P1.Username == c.Username {
-		if inst.P2 != nil {
-			other = inst.P2.Username
-		} else {
-			other = "BOT"
+	loser := P2
+	if inst.P1 == c {
+		loser = P1
+	}
+	inst.Game.Forfeit(loser)
+	inst.log.Info("player left, game forfeited", "op", "forfeit", "username", c.Username)
+	h.finishGame(inst, true)
+}
+
+// resume reattaches a client to a game that was suspended by a previous
+// shutdown. Human games only restart once both players are back.
+func (h *Hub) resume(c *WSClient) bool {
+	stored := h.findSuspended(c.Username)
+	if stored == nil {
+		return false
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closing || c.released {
+		return false
+	}
+
+	inst, ok := h.resuming[stored.GameID]
+	if !ok {
+		g, err := Replay(stored.GameID, stored.Player1, stored.Player2, stored.Start, stored.MoveLog)
+		if err != nil {
+			c.log.Error("could not replay suspended game", "op", "resume", "game_id", stored.GameID, "error", err)
+			return false
 		}
-	} else {
-		other = inst.P1.Username
+		g.StartedAt = stored.StartedAt
+		g.Player1ID, g.Player2ID = stored.Player1ID, stored.Player2ID
+		inst = &GameInstance{Game: g, CreatedAt: time.Now(), Hints: stored.Hints, VsBot: stored.VsBot, log: h.log.With("game_id", stored.GameID)}
+		if isBotName(stored.Player2) {
+			inst.VsBot = true
+			engine := stored.BotEngine
+			if engine == "" {
+				engine = botEngineOf(stored.Player2)
+			}
+			bot, persona, ok := h.newBot(engine)
+			if !ok {
+				inst.log.Warn("bot engine no longer exists, resuming with the heuristic", "op", "resume", "bot", engine)
+				bot, persona, _ = h.newBot("heuristic")
+			}
+			inst.Bot, inst.Persona = bot, persona
+		}
+		h.resuming[stored.GameID] = inst
+	}
+	seat := &inst.P2
+	if c.Username == inst.Game.Player1 {
+		seat = &inst.P1
+	}
+	// A bot account's other sockets queue for new games rather than take
+	// the seat from the one already resuming
+	if old := *seat; old != nil && old != c {
+		if _, live := h.clients[old]; live && c.Bot {
+			return false
+		}
+	}
+	*seat = c
+	c.GameID = stored.GameID
+
+	botGame := inst.Bot != nil
+	if !botGame && (inst.P1 == nil || inst.P2 == nil) {
+		h.sendJSON(c, WSMessage{Type: "waiting_resume", GameID: stored.GameID, Payload: map[string]interface{}{
+			"timeout_seconds": int(h.cfg.ResumeTimeout.Seconds()),
+		}})
+		if inst.expiry == nil {
+			inst.expiry = time.AfterFunc(h.cfg.ResumeTimeout, func() { h.expireResume(inst) })
+		}
+		return true
+	}
+	if inst.expiry != nil {
+		inst.expiry.Stop()
 	}
 
-	inst.Game.Finished = true
-	inst.Game.WinnerUser = other
+	delete(h.resuming, stored.GameID)
+	h.games[stored.GameID] = inst
+	h.updateGauges()
 
-	endMsg := WSMessage{Type: "end", GameID: gameID, Payload: map[string]interface{}{
-		"winner":  other,
-		"forfeit": true,
-	}}
+	if h.db != nil {
+		coll := h.db.Database.Collection("games")
+		_, err := coll.UpdateOne(
+			context.TODO(),
+			bson.M{"game_id": stored.GameID},
+			bson.M{"$set": bson.M{"suspended": false, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			inst.log.Error("failed to clear suspended flag", "op", "resume", "error", err)
+		}
+	}
+
+	startMsg := WSMessage{
+		Type:   "start",
+		GameID: stored.GameID,
+		Payload: map[string]interface{}{
+			"player1": inst.Game.Player1,
+			"player2": inst.Game.Player2,
+			"board":   inst.Game.Board,
+			"turn":    inst.Game.CurrentPlayerName(),
+			"resumed": true,
+		},
+	}
 	if inst.P1 != nil {
-		h.sendJSON(inst.P1, endMsg)
+		h.sendJSON(inst.P1, startMsg)
 	}
 	if inst.P2 != nil {
-		h.sendJSON(inst.P2, endMsg)
+		h.sendJSON(inst.P2, startMsg)
+	}
+	inst.log.Info("game resumed", "op", "resume", "moves", inst.Game.Moves)
+	if botGame {
+		go h.botLoop(inst)
 	}
+	return true
+}
 
+// expireResume aborts a suspended game whose opponent has not come back
+// within the resume timeout and sends whoever was waiting to matchmaking.
+// Nothing is rated, since the server stopped the game, not a player.
+func (h *Hub) expireResume(inst *GameInstance) {
+	h.mu.Lock()
+	if h.closing || h.resuming[inst.Game.ID] != inst {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.resuming, inst.Game.ID)
 	if h.db != nil {
-		resColl := h.db.Database.Collection("game_results")
-		gameColl := h.db.Database.Collection("games")
+		_, err := h.db.Database.Collection("games").UpdateOne(
+			context.TODO(),
+			bson.M{"game_id": inst.Game.ID},
+			bson.M{"$set": bson.M{"finished": true, "aborted": true, "suspended": false, "updated_at": time.Now()}},
+		)
+		if err != nil {
+			inst.log.Error("failed to abort expired game", "op", "resume", "error", err)
+		}
+	}
+	inst.log.Info("opponent did not return, game aborted", "op", "resume", "timeout", h.cfg.ResumeTimeout.String())
+	var requeue []*WSClient
+	for _, c := range []*WSClient{inst.P1, inst.P2} {
+		if _, live := h.clients[c]; c == nil || !live {
+			continue
+		}
+		c.GameID = ""
+		h.sendJSON(c, WSMessage{Type: "resume_expired", GameID: inst.Game.ID})
+		requeue = append(requeue, c)
+	}
+	h.mu.Unlock()
 
-		_, err := resColl.InsertOne(context.TODO(), GameResult{
-			GameID:    inst.Game.ID,
-			Player1:   inst.Game.Player1,
-			Player2:   inst.Game.Player2,
-			Winner:    inst.Game.WinnerUser,
-			Moves:     inst.Game.Moves,
-			Duration:  time.Since(inst.Game.StartedAt),
-			CreatedAt: time.Now(),
-		})
+	for _, c := range requeue {
+		h.addToQueue(c)
+	}
+}
+
+// LoadSuspended reads which players have a suspended game, so that joins
+// from everyone else skip the lookup. Until it succeeds every join is
+// looked up.
+func (h *Hub) LoadSuspended(ctx context.Context) error {
+	if h.db == nil {
+		return nil
+	}
+	players := map[string]bool{}
+	for _, field := range []string{"player1", "player2"} {
+		names, err := h.db.Database.Collection("games").Distinct(ctx, field, bson.M{"suspended": true})
 		if err != nil {
-			log.Println("Error inserting forfeit result:", err)
+			return err
 		}
+		for _, n := range names {
+			if name, ok := n.(string); ok {
+				players[name] = true
+			}
+		}
+	}
+	h.mu.Lock()
+	h.suspended = players
+	h.mu.Unlock()
+	return nil
+}
+
+// findSuspended looks up the most recent suspended game the user took part in
+func (h *Hub) findSuspended(username string) *GameDB {
+	if h.db == nil {
+		return nil
+	}
+	h.mu.Lock()
+	skip := h.suspended != nil && !h.suspended[username]
+	h.mu.Unlock()
+	if skip {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var g GameDB
+	err := h.db.Database.Collection("games").FindOne(ctx, bson.M{
+		"suspended": true,
+		"$or":       bson.A{bson.M{"player1": username}, bson.M{"player2": username}},
+	}, options.FindOne().SetSort(bson.D{{Key: "updated_at", Value: -1}})).Decode(&g)
+	if err == mongo.ErrNoDocuments {
+		h.mu.Lock()
+		if h.suspended != nil {
+			delete(h.suspended, username)
+		}
+		h.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		h.log.Error("suspended game lookup failed", "op", "resume", "username", username, "error", err)
+		return nil
+	}
+	return &g
+}
 
-		_, err = gameColl.UpdateOne(
+// suspendGame persists a live game so it can be resumed after a restart.
+// Caller must hold h.mu.
+func (h *Hub) suspendGame(inst *GameInstance) {
+	if h.db != nil {
+		coll := h.db.Database.Collection("games")
+		_, err := coll.UpdateOne(
 			context.TODO(),
 			bson.M{"game_id": inst.Game.ID},
 			bson.M{"$set": bson.M{
-				"finished":   true,
-				"winner":     inst.Game.WinnerUser,
+				"suspended":  true,
+				"move_log":   inst.Game.History,
 				"updated_at": time.Now(),
 			}},
 		)
 		if err != nil {
-			log.Println("Error updating forfeit game:", err)
+			inst.log.Error("failed to suspend game", "op", "suspend", "error", err)
+		}
+	}
+	if h.suspended != nil {
+		h.suspended[inst.Game.Player1] = true
+		h.suspended[inst.Game.Player2] = true
+	}
+	inst.log.Info("game suspended", "op", "suspend", "moves", inst.Game.Moves)
+	delete(h.games, inst.Game.ID)
+	h.updateGauges()
+}
+
+// Shutdown stops matchmaking, tells every connected client that the server is
+// going away and gives live games up to drain to finish. Games still running
+// after that are suspended for later resumption and all sockets are closed.
+func (h *Hub) Shutdown(ctx context.Context, drain time.Duration) {
+	h.mu.Lock()
+	h.closing = true
+	h.waiting = nil
+	h.updateGauges()
+	notice := WSMessage{Type: "server_shutdown", Payload: map[string]interface{}{
+		"drain_seconds": int(drain.Seconds()),
+	}}
+	for c := range h.clients {
+		h.sendJSON(c, notice)
+	}
+	h.mu.Unlock()
+
+	deadline := time.NewTimer(drain)
+	defer deadline.Stop()
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+wait:
+	for {
+		h.mu.Lock()
+		live := len(h.games)
+		h.mu.Unlock()
+		if live == 0 {
+			break
+		}
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			break wait
+		case <-ctx.Done():
+			break wait
 		}
 	}
 
-	delete(h.games, gameID)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.games) > 0 {
+		h.log.Warn("suspending games still live after drain", "op", "shutdown", "games", len(h.games))
+	}
+	for _, inst := range h.games {
+		h.suspendGame(inst)
+	}
+
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown")
+	for c := range h.clients {
+		if err := c.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second)); err != nil {
+			c.log.Warn("failed to send close frame", "op", "shutdown", "error", err)
+		}
+		c.Conn.Close()
+	}
+}
+
+// Accepting reports whether the hub still takes new connections
+func (h *Hub) Accepting() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.closing
+}
+
+// LiveGames returns the number of games in progress
+func (h *Hub) LiveGames() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.games)
+}
+
+// LiveGameIDs returns the games this process is playing or waiting to
+// resume
+func (h *Hub) LiveGameIDs() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := make([]string, 0, len(h.games)+len(h.resuming))
+	for id := range h.games {
+		ids = append(ids, id)
+	}
+	for id := range h.resuming {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+type ClientState struct {
+	Username string `json:"username"`
+	Addr     string `json:"remote_addr"`
+	GameID   string `json:"game_id,omitempty"`
+	Queued   int    `json:"queued_messages"`
+}
+
+type GameState struct {
+	GameID    string             `json:"game_id"`
+	Player1   string             `json:"player1"`
+	Player2   string             `json:"player2"`
+	Board     [Rows][Cols]Player `json:"board"`
+	Turn      string             `json:"turn"`
+	Moves     int                `json:"moves"`
+	StartedAt time.Time          `json:"started_at"`
+}
+
+type HubState struct {
+	Closing  bool          `json:"closing"`
+	Waiting  []ClientState `json:"waiting"`
+	Games    []GameState   `json:"games"`
+	Resuming []GameState   `json:"resuming"`
+	Clients  []ClientState `json:"clients"`
+}
+
+// Snapshot copies the hub state for diagnostics
+func (h *Hub) Snapshot() HubState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := func(c *WSClient) ClientState {
+		return ClientState{Username: c.Username, Addr: c.Addr, GameID: c.GameID, Queued: len(c.Send)}
+	}
+	game := func(inst *GameInstance) GameState {
+		g := inst.Game
+		return GameState{
+			GameID:    g.ID,
+			Player1:   g.Player1,
+			Player2:   g.Player2,
+			Board:     g.Board,
+			Turn:      g.CurrentPlayerName(),
+			Moves:     g.Moves,
+			StartedAt: g.StartedAt,
+		}
+	}
+
+	s := HubState{
+		Closing:  h.closing,
+		Waiting:  []ClientState{},
+		Games:    []GameState{},
+		Resuming: []GameState{},
+		Clients:  []ClientState{},
+	}
+	for _, c := range h.waiting {
+		s.Waiting = append(s.Waiting, client(c))
+	}
+	for _, inst := range h.games {
+		s.Games = append(s.Games, game(inst))
+	}
+	for _, inst := range h.resuming {
+		s.Resuming = append(s.Resuming, game(inst))
+	}
+	for c := range h.clients {
+		s.Clients = append(s.Clients, client(c))
+	}
+	return s
 }
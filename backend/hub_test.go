@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// received decodes the messages waiting in c's send buffer
+func received(t *testing.T, c *WSClient) []WSMessage {
+	t.Helper()
+	var msgs []WSMessage
+	for {
+		select {
+		case b := <-c.Send:
+			var m WSMessage
+			if err := json.Unmarshal(b, &m); err != nil {
+				t.Fatalf("bad message %s: %v", b, err)
+			}
+			msgs = append(msgs, m)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestExpireResumeRequeues(t *testing.T) {
+	h := newTestHub(nil)
+	alice := newTestClient("alice", 16)
+	g := NewGame("g1", "alice", "bob")
+	inst := &GameInstance{Game: g, P1: alice, log: h.log}
+	alice.GameID = g.ID
+	h.clients[alice] = struct{}{}
+	h.resuming[g.ID] = inst
+
+	h.expireResume(inst)
+
+	if _, ok := h.resuming[g.ID]; ok {
+		t.Error("expired game is still waiting to resume")
+	}
+	if alice.GameID != "" {
+		t.Errorf("alice is still in game %q", alice.GameID)
+	}
+	if len(h.waiting) != 1 || h.waiting[0] != alice {
+		t.Errorf("waiting = %v, want alice back in the queue", h.waiting)
+	}
+	msgs := received(t, alice)
+	if len(msgs) == 0 || msgs[0].Type != "resume_expired" || msgs[0].GameID != g.ID {
+		t.Errorf("alice got %+v, want resume_expired for %s first", msgs, g.ID)
+	}
+
+	// A game that resumed or was dropped meanwhile is left alone
+	h.expireResume(inst)
+	if len(h.waiting) != 1 {
+		t.Errorf("a second expiry queued alice again")
+	}
+}
+
+func TestHintCooldownFollowsClock(t *testing.T) {
+	h := newTestHub(nil)
+	clock := &fakeClock{now: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	h.clock = clock
+	alice := newTestClient("alice", 16)
+	g := NewGame("g1", "alice", BotPlayerName("heuristic"))
+	h.games[g.ID] = &GameInstance{Game: g, P1: alice, Bot: heuristicEngine{}, VsBot: true, log: h.log}
+	alice.GameID = g.ID
+
+	lastType := func() string {
+		msgs := received(t, alice)
+		if len(msgs) != 1 {
+			t.Fatalf("alice got %+v, want one message", msgs)
+		}
+		return msgs[0].Type
+	}
+	h.handleHint(alice)
+	if got := lastType(); got != "hint" {
+		t.Fatalf("first request answered %q, want hint", got)
+	}
+	clock.now = clock.now.Add(h.cfg.HintCooldown - time.Millisecond)
+	h.handleHint(alice)
+	if got := lastType(); got != "error" {
+		t.Errorf("request within the cooldown answered %q, want error", got)
+	}
+	clock.now = clock.now.Add(time.Millisecond)
+	h.handleHint(alice)
+	if got := lastType(); got != "hint" {
+		t.Errorf("request after the cooldown answered %q, want hint", got)
+	}
+}
+
+func TestDisconnectClosesSendOnce(t *testing.T) {
+	h := newTestHub(nil)
+	alice, bob := newTestClient("alice", 16), newTestClient("bob", 16)
+	for _, c := range []*WSClient{alice, bob} {
+		if _, err := h.claimSession(c); err != nil {
+			t.Fatal(err)
+		}
+		h.addToQueue(c)
+	}
+	received(t, alice)
+	received(t, bob)
+
+	h.handleDisconnect(alice)
+	msgs := received(t, bob)
+	if len(msgs) == 0 || msgs[len(msgs)-1].Type != "end" {
+		t.Errorf("bob got %+v, want the forfeit", msgs)
+	}
+	// What was queued before the release drains, then the channel ends
+	for range alice.Send {
+	}
+	if _, ok := h.clients[alice]; ok {
+		t.Error("alice is still a client")
+	}
+
+	// Later sends and a second release must not touch the closed channel
+	h.sendJSON(alice, WSMessage{Type: "error"})
+	h.handleDisconnect(alice)
+	h.addToQueue(alice)
+	if len(h.waiting) != 0 {
+		t.Errorf("released client queued again: %v", h.waiting)
+	}
+}
@@ -1,39 +1,140 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/Shopify/sarama"
 )
 
+// publishBuffer is how many events Publish queues before it starts dropping
+// them
+const publishBuffer = 1024
+
 type KafkaProducer struct {
+	client   sarama.Client
 	producer sarama.SyncProducer
+
+	mu     sync.Mutex // guards closed and sends on events
+	closed bool
+	events chan *sarama.ProducerMessage
+	done   chan struct{} // closed once the sender has drained events
 }
 
 func NewKafkaProducer(brokers []string) (*KafkaProducer, error) {
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
 	config.Producer.Timeout = 5 * time.Second
-	p, err := sarama.NewSyncProducer(brokers, config)
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	p, err := sarama.NewSyncProducerFromClient(client)
 	if err != nil {
+		client.Close()
 		return nil, err
 	}
-	return &KafkaProducer{producer: p}, nil
+	kp := &KafkaProducer{
+		client:   client,
+		producer: p,
+		events:   make(chan *sarama.ProducerMessage, publishBuffer),
+		done:     make(chan struct{}),
+	}
+	go kp.send()
+	return kp, nil
+}
+
+// Ping checks that at least one broker answers a metadata request. sarama
+// takes no context, so the request runs on its own and Ping gives up on it
+// when ctx ends.
+func (kp *KafkaProducer) Ping(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() { errc <- kp.client.RefreshMetadata() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (kp *KafkaProducer) SendEvent(topic string, payload any) {
 	if kp == nil {
 		return
 	}
-	b, _ := json.Marshal(payload)
+	b, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("failed to encode kafka event", "op", "kafka_send", "topic", topic, "error", err)
+		return
+	}
 	msg := &sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.ByteEncoder(b),
 	}
-	_, _, err := kp.producer.SendMessage(msg)
+	if _, _, err := kp.producer.SendMessage(msg); err != nil {
+		slog.Error("kafka send failed", "op", "kafka_send", "topic", topic, "error", err)
+	}
+}
+
+// Publish queues an event for the producer's sender, which delivers events
+// one at a time in the order they were published. It never blocks, so
+// callers may hold the hub lock; when the queue is full or the producer is
+// closed the event is dropped and logged.
+func (kp *KafkaProducer) Publish(topic string, payload any) {
+	if kp == nil {
+		return
+	}
+	b, err := json.Marshal(payload)
 	if err != nil {
-		log.Println("kafka send error:", err)
+		slog.Error("failed to encode kafka event", "op", "kafka_send", "topic", topic, "error", err)
+		return
+	}
+	msg := &sarama.ProducerMessage{Topic: topic, Value: sarama.ByteEncoder(b)}
+
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if kp.closed {
+		slog.Warn("dropped kafka event after close", "op", "kafka_send", "topic", topic)
+		return
+	}
+	select {
+	case kp.events <- msg:
+	default:
+		slog.Warn("dropped kafka event, queue full", "op", "kafka_send", "topic", topic)
+	}
+}
+
+// send delivers queued events until Close closes the queue
+func (kp *KafkaProducer) send() {
+	defer close(kp.done)
+	for msg := range kp.events {
+		if _, _, err := kp.producer.SendMessage(msg); err != nil {
+			slog.Error("kafka send failed", "op", "kafka_send", "topic", msg.Topic, "error", err)
+		}
+	}
+}
+
+// Close stops accepting events, waits for the queued ones to be sent and
+// closes the producer
+func (kp *KafkaProducer) Close() error {
+	if kp == nil {
+		return nil
+	}
+	kp.mu.Lock()
+	if kp.closed {
+		kp.mu.Unlock()
+		return nil
+	}
+	kp.closed = true
+	close(kp.events)
+	kp.mu.Unlock()
+
+	<-kp.done
+	if err := kp.producer.Close(); err != nil {
+		return err
 	}
+	return kp.client.Close()
 }
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+)
+
+// closeOnlyClient stands in for the broker client, which Close only closes
+type closeOnlyClient struct{ sarama.Client }
+
+func (closeOnlyClient) Close() error { return nil }
+
+func TestPublishKeepsOrderAndCloseDrains(t *testing.T) {
+	const n = 50
+	mock := mocks.NewSyncProducer(t, nil)
+	var got []int
+	for i := 0; i < n; i++ {
+		mock.ExpectSendMessageWithCheckerFunctionAndSucceed(func(b []byte) error {
+			var ev struct{ Seq int }
+			if err := json.Unmarshal(b, &ev); err != nil {
+				return err
+			}
+			got = append(got, ev.Seq)
+			return nil
+		})
+	}
+	kp := &KafkaProducer{
+		client:   closeOnlyClient{},
+		producer: mock,
+		events:   make(chan *sarama.ProducerMessage, publishBuffer),
+		done:     make(chan struct{}),
+	}
+	go kp.send()
+
+	for i := 0; i < n; i++ {
+		kp.Publish("move", map[string]int{"Seq": i})
+	}
+	if err := kp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != n {
+		t.Fatalf("sent %d events before Close returned, want %d", len(got), n)
+	}
+	for i, seq := range got {
+		if seq != i {
+			t.Fatalf("event %d carried seq %d; events were reordered", i, seq)
+		}
+	}
+
+	kp.Publish("move", map[string]int{"Seq": n})
+	if err := kp.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+// stuckClient never hears back from a broker
+type stuckClient struct {
+	sarama.Client
+	release chan struct{}
+}
+
+func (c stuckClient) RefreshMetadata(...string) error {
+	<-c.release
+	return nil
+}
+
+func TestPingGivesUpWithContext(t *testing.T) {
+	client := stuckClient{release: make(chan struct{})}
+	defer close(client.release)
+	kp := &KafkaProducer{client: client}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := kp.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ping = %v, want context.DeadlineExceeded", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("Ping took %s after its context ended", took)
+	}
+}
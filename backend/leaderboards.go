@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// leaderboardQuery is the filter and page requested from a leaderboard
+type leaderboardQuery struct {
+	From, To    time.Time // zero means unbounded
+	IncludeBots bool
+	MinGames    int
+	Variant     string
+	Offset      int
+	Limit       int
+	Cursor      *leaderboardCursor
+	PlayerID    string // the caller, whose position is reported separately
+}
+
+// leaderboardCursor marks the last row of a page. Rows are ordered by the
+// board's sort value and then by player ID, so the pair is unique.
+type leaderboardCursor struct {
+	Value float64 `json:"v"`
+	ID    string  `json:"id"`
+}
+
+func (c leaderboardCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*leaderboardCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c leaderboardCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID == "" {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// parseDate accepts RFC 3339 timestamps or plain dates
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+// parseLeaderboardQuery reads the period, filters and page from the query
+// string. maxLimit caps the page size.
+func parseLeaderboardQuery(r *http.Request, now time.Time, maxLimit int) (leaderboardQuery, error) {
+	q := r.URL.Query()
+	lq := leaderboardQuery{
+		IncludeBots: false,
+		MinGames:    1,
+		Variant:     VariantClassic,
+		Limit:       queryLimit(r, "limit", maxLimit, maxLimit),
+	}
+
+	switch period := q.Get("period"); period {
+	case "", "all":
+	case "day":
+		lq.From = now.AddDate(0, 0, -1)
+	case "week":
+		lq.From = now.AddDate(0, 0, -7)
+	case "month":
+		lq.From = now.AddDate(0, -1, 0)
+	case "custom":
+		from, err := parseDate(q.Get("from"))
+		if err != nil {
+			return lq, errors.New("period=custom needs from as a date or RFC 3339 time")
+		}
+		lq.From, lq.To = from, now
+		if s := q.Get("to"); s != "" {
+			if lq.To, err = parseDate(s); err != nil {
+				return lq, errors.New("to must be a date or RFC 3339 time")
+			}
+		}
+		if !lq.To.After(lq.From) {
+			return lq, errors.New("to must be after from")
+		}
+	default:
+		return lq, fmt.Errorf("unknown period %q, use day, week, month, all or custom", period)
+	}
+
+	if s := q.Get("bots"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return lq, errors.New("bots must be true or false")
+		}
+		lq.IncludeBots = b
+	}
+	if s := q.Get("min_games"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return lq, errors.New("min_games must be a positive integer")
+		}
+		lq.MinGames = n
+	}
+	if s := q.Get("variant"); s != "" {
+		lq.Variant = s
+	}
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return lq, errors.New("offset must be a non-negative integer")
+		}
+		lq.Offset = n
+	}
+	if s := q.Get("cursor"); s != "" {
+		if lq.Offset > 0 {
+			return lq, errors.New("use either offset or cursor, not both")
+		}
+		c, err := decodeCursor(s)
+		if err != nil {
+			return lq, err
+		}
+		lq.Cursor = c
+	}
+	return lq, nil
+}
+
+// board describes how one leaderboard ranks players
+type board struct {
+	sortField string
+	sortDir   int // -1 for highest first
+}
+
+var (
+	winsBoard       = board{sortField: "wins", sortDir: -1}
+	efficiencyBoard = board{sortField: "avg_moves", sortDir: 1}
+)
+
+// LeaderboardRow is a ranked player. Players with the same sort value share
+// a rank.
+type LeaderboardRow struct {
+	Rank     int     `json:"rank"`
+	PlayerID string  `json:"player_id"`
+	Username string  `json:"username"`
+	Games    int     `json:"games"`
+	Wins     int     `json:"wins"`
+	AvgMoves float64 `json:"avg_moves,omitempty"`
+	MinMoves int     `json:"min_moves,omitempty"`
+	MaxMoves int     `json:"max_moves,omitempty"`
+}
+
+type leaderboardDoc struct {
+	Rank     int     `bson:"rank"`
+	PlayerID string  `bson:"_id"`
+	Username string  `bson:"username"`
+	Games    int     `bson:"games"`
+	Wins     int     `bson:"wins"`
+	AvgMoves float64 `bson:"avg_moves"`
+	MinMoves int     `bson:"min_moves"`
+	MaxMoves int     `bson:"max_moves"`
+}
+
+func (d leaderboardDoc) row() LeaderboardRow {
+	return LeaderboardRow(d)
+}
+
+// LeaderboardPage is one page of a leaderboard. Me is the caller's own row,
+// present even when it falls outside the page.
+type LeaderboardPage struct {
+	Entries    []LeaderboardRow `json:"entries"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Me         *LeaderboardRow  `json:"me,omitempty"`
+}
+
+// rankedStages matches results, folds them into one row per player and ranks
+// the rows. Both sides of every game count towards games played; only the
+// rows that pass the thresholds are ranked.
+func (b board) rankedStages(q leaderboardQuery) mongo.Pipeline {
+	match := bson.M{"rated": true, "variant": q.Variant}
+	if !q.IncludeBots {
+		match["vs_bot"] = false
+	}
+	if !q.From.IsZero() || !q.To.IsZero() {
+		created := bson.M{}
+		if !q.From.IsZero() {
+			created["$gte"] = q.From
+		}
+		if !q.To.IsZero() {
+			created["$lt"] = q.To
+		}
+		match["created_at"] = created
+	}
+
+	side := func(id, name string, win Result) bson.M {
+		return bson.M{"id": "$" + id, "name": "$" + name, "won": bson.M{"$eq": bson.A{"$outcome", win}}}
+	}
+	wonMoves := bson.M{"$cond": bson.A{"$sides.won", "$moves", nil}}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$project", Value: bson.M{
+			"moves": 1,
+			"sides": bson.A{
+				side("player1_id", "player1", ResultP1Win),
+				side("player2_id", "player2", ResultP2Win),
+			},
+		}}},
+		{{Key: "$unwind", Value: "$sides"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       "$sides.id",
+			"username":  bson.M{"$last": "$sides.name"},
+			"games":     bson.M{"$sum": 1},
+			"wins":      bson.M{"$sum": bson.M{"$cond": bson.A{"$sides.won", 1, 0}}},
+			"avg_moves": bson.M{"$avg": wonMoves},
+			"min_moves": bson.M{"$min": wonMoves},
+			"max_moves": bson.M{"$max": wonMoves},
+		}}},
+		{{Key: "$match", Value: bson.M{"wins": bson.M{"$gte": 1}, "games": bson.M{"$gte": q.MinGames}}}},
+		{{Key: "$setWindowFields", Value: bson.M{
+			"sortBy": bson.M{b.sortField: b.sortDir},
+			"output": bson.M{"rank": bson.M{"$rank": bson.M{}}},
+		}}},
+	}
+}
+
+// page runs the board for one page of rows and, if the query names a
+// player, that player's row
+func (b board) page(ctx context.Context, db *MongoDB, q leaderboardQuery) (LeaderboardPage, error) {
+	page := LeaderboardPage{Entries: []LeaderboardRow{}}
+	coll := db.Database.Collection("game_results")
+
+	pipeline := b.rankedStages(q)
+	pipeline = append(pipeline, bson.D{{Key: "$sort", Value: bson.D{{Key: b.sortField, Value: b.sortDir}, {Key: "_id", Value: 1}}}})
+	if c := q.Cursor; c != nil {
+		past := "$gt"
+		if b.sortDir < 0 {
+			past = "$lt"
+		}
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{b.sortField: bson.M{past: c.Value}},
+			bson.M{b.sortField: c.Value, "_id": bson.M{"$gt": c.ID}},
+		}}}})
+	}
+	if q.Offset > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: q.Offset}})
+	}
+	pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit + 1}})
+
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return page, err
+	}
+	var docs []leaderboardDoc
+	if err := cursor.All(ctx, &docs); err != nil {
+		return page, err
+	}
+	if len(docs) > q.Limit {
+		docs = docs[:q.Limit]
+		last := docs[len(docs)-1]
+		value := float64(last.Wins)
+		if b.sortField == "avg_moves" {
+			value = last.AvgMoves
+		}
+		page.NextCursor = leaderboardCursor{Value: value, ID: last.PlayerID}.encode()
+	}
+	for _, d := range docs {
+		page.Entries = append(page.Entries, d.row())
+	}
+
+	if q.PlayerID != "" {
+		mine := append(b.rankedStages(q), bson.D{{Key: "$match", Value: bson.M{"_id": q.PlayerID}}})
+		cursor, err := coll.Aggregate(ctx, mine)
+		if err != nil {
+			return page, err
+		}
+		var me []leaderboardDoc
+		if err := cursor.All(ctx, &me); err != nil {
+			return page, err
+		}
+		if len(me) > 0 {
+			row := me[0].row()
+			page.Me = &row
+		}
+	}
+	return page, nil
+}
+
+// registerLeaderboardRoutes mounts /leaderboard (most wins) and /efficiency
+// (fewest moves per win). A valid token adds the caller's own position.
+func registerLeaderboardRoutes(mux *http.ServeMux, cfg APIConfig, db *MongoDB, auth *AuthService) {
+	serve := func(op string, b board) http.HandlerFunc {
+		return withRequestLog(op, func(w http.ResponseWriter, r *http.Request) {
+			q, err := parseLeaderboardQuery(r, time.Now(), cfg.LeaderboardLimit)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if token := tokenFrom(r); token != "" {
+				if id, err := auth.VerifyToken(token); err == nil {
+					q.PlayerID = id.UserID
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+			defer cancel()
+
+			page, err := b.page(ctx, db, q)
+			if err != nil {
+				requestLogger(r, op).Error("leaderboard query failed", "error", err)
+				writeError(w, http.StatusInternalServerError, "query failed")
+				return
+			}
+			writeJSON(w, page)
+		})
+	}
+	mux.HandleFunc("/leaderboard", serve("leaderboard", winsBoard))
+	mux.HandleFunc("/efficiency", serve("efficiency", efficiencyBoard))
+}
@@ -3,176 +3,118 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func writeJSON(w http.ResponseWriter, v interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+func setCORSHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-	json.NewEncoder(w).Encode(v)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 }
 
-func main() {
-	db := InitDB()
-	if db == nil {
-		log.Fatal("❌ MongoDB initialization failed")
-	}
-
-	hub := NewHub(db)
-	http.HandleFunc("/ws", hub.ServeWS)
-
-	// ---------------- Leaderboard ----------------
-	http.HandleFunc("/leaderboard", func(w http.ResponseWriter, r *http.Request) {
-		type LB struct {
-			Username string `json:"username"`
-			Wins     int    `json:"wins"`
-		}
-		var res []LB
-		coll := db.Database.Collection("game_results")
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+// writeJSONStatus sends v with the given status
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	setCORSHeaders(w)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("failed to write response", "op", "write_json", "error", err)
+	}
+}
 
-		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: bson.M{"winner": bson.M{"$ne": "draw"}}}},
-			{{Key: "$group", Value: bson.M{
-				"_id":  "$winner",
-				"wins": bson.M{"$sum": 1},
-			}}},
-			{{Key: "$sort", Value: bson.M{"wins": -1}}},
-			{{Key: "$limit", Value: 50}},
-		}
+// writeError sends {"error": msg} with the given status
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	setCORSHeaders(w)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": msg}); err != nil {
+		slog.Warn("failed to write response", "op", "write_error", "error", err)
+	}
+}
 
-		cursor, err := coll.Aggregate(ctx, pipeline)
-		if err != nil {
-			log.Println("leaderboard query error:", err)
-			writeJSON(w, res)
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			runCommand(os.Args[1], cmd, os.Args[2:])
 			return
 		}
-		defer cursor.Close(ctx)
-
-		for cursor.Next(ctx) {
-			var doc struct {
-				ID   string `bson:"_id"`
-				Wins int    `bson:"wins"`
-			}
-			if err := cursor.Decode(&doc); err == nil {
-				res = append(res, LB{Username: doc.ID, Wins: doc.Wins})
-			}
-		}
-		writeJSON(w, res)
-	})
-
-	// ---------------- Efficiency Leaderboard ----------------
-	http.HandleFunc("/efficiency", func(w http.ResponseWriter, r *http.Request) {
-		type Eff struct {
-			Username string  `json:"username"`
-			Wins     int     `json:"wins"`
-			AvgMoves float64 `json:"avg_moves"`
-			MinMoves int64   `json:"min_moves"`
-			MaxMoves int64   `json:"max_moves"`
-		}
-		var res []Eff
-		coll := db.Database.Collection("game_results")
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	cfg, rest := mustLoadConfig(os.Args[1:])
+	if len(rest) > 0 {
+		slog.Error("unknown command, commands go before flags", "op", "startup", "command", rest[0])
+		os.Exit(2)
+	}
+	slog.SetDefault(NewLogger(cfg.Log, os.Stderr))
+	RegisterExternalEngines(cfg.Game.ExternalEngines)
 
-		pipeline := mongo.Pipeline{
-			{{Key: "$match", Value: bson.M{"winner": bson.M{"$ne": "draw"}, "moves": bson.M{"$ne": nil}}}},
-			{{Key: "$group", Value: bson.M{
-				"_id":       "$winner",
-				"wins":      bson.M{"$sum": 1},
-				"avg_moves": bson.M{"$avg": "$moves"},
-				"min_moves": bson.M{"$min": "$moves"},
-				"max_moves": bson.M{"$max": "$moves"},
-			}}},
-			{{Key: "$sort", Value: bson.M{"avg_moves": 1}}},
-			{{Key: "$limit", Value: 50}},
-		}
+	metrics := NewPromMetrics()
+	db := InitDB(cfg.Mongo, metrics)
+	if db == nil {
+		slog.Error("MongoDB initialization failed", "op", "startup")
+		os.Exit(1)
+	}
+	migrateOnStart(cfg.Mongo, db)
 
-		cursor, err := coll.Aggregate(ctx, pipeline)
+	var kafka *KafkaProducer
+	if len(cfg.Kafka.Brokers) > 0 {
+		kp, err := NewKafkaProducer(cfg.Kafka.Brokers)
 		if err != nil {
-			log.Println("efficiency query error:", err)
-			writeJSON(w, res)
-			return
-		}
-		defer cursor.Close(ctx)
-
-		for cursor.Next(ctx) {
-			var doc struct {
-				ID       string  `bson:"_id"`
-				Wins     int     `bson:"wins"`
-				AvgMoves float64 `bson:"avg_moves"`
-				MinMoves int64   `bson:"min_moves"`
-				MaxMoves int64   `bson:"max_moves"`
-			}
-			if err := cursor.Decode(&doc); err == nil {
-				res = append(res, Eff{
-					Username: doc.ID,
-					Wins:     doc.Wins,
-					AvgMoves: doc.AvgMoves,
-					MinMoves: doc.MinMoves,
-					MaxMoves: doc.MaxMoves,
-				})
-			}
+			slog.Warn("kafka disabled", "op", "startup", "brokers", cfg.Kafka.Brokers, "error", err)
+		} else {
+			kafka = kp
 		}
-		writeJSON(w, res)
-	})
-
-	// ---------------- Stats ----------------
-	http.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
-		type Stats struct {
-			TotalPlayers int64 `json:"total_players"`
-			TotalGames   int64 `json:"total_games"`
-			TotalDraws   int64 `json:"total_draws"`
-		}
-		var s Stats
-
-		coll := db.Database.Collection("game_results")
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+	}
 
-		cursor, err := coll.Find(ctx, bson.M{})
-		if err == nil {
-			playerSet := make(map[string]struct{})
-			for cursor.Next(ctx) {
-				var doc struct {
-					Player1 string `bson:"player1"`
-					Player2 string `bson:"player2"`
-					Winner  string `bson:"winner"`
-				}
-				if err := cursor.Decode(&doc); err == nil {
-					playerSet[doc.Player1] = struct{}{}
-					playerSet[doc.Player2] = struct{}{}
-					s.TotalGames++
-					if strings.ToLower(doc.Winner) == "draw" {
-						s.TotalDraws++
-					}
-				}
-			}
-			cursor.Close(ctx)
-			s.TotalPlayers = int64(len(playerSet))
+	auth := NewAuthService(cfg.Auth, db)
+	var book *OpeningBook
+	if cfg.Game.OpeningBook != "" {
+		b, err := LoadBook(cfg.Game.OpeningBook)
+		if err != nil {
+			slog.Error("could not load opening book", "op", "startup", "error", err)
+			os.Exit(1)
 		}
-		writeJSON(w, s)
-	})
+		slog.Info("opening book loaded", "op", "startup", "positions", b.Len(), "plies", b.Plies)
+		book = b
+	}
+	hub := NewHub(cfg.Game, db, kafka, metrics, book)
+	if err := hub.LoadSuspended(context.Background()); err != nil {
+		slog.Warn("could not read suspended games, looking them up on every join", "op", "startup", "error", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", auth.OptionalAuth(hub.ServeWS))
+	registerAuthRoutes(mux, auth)
+	mux.Handle("/metrics", metrics.Handler())
+	registerHealthRoutes(mux, cfg.Debug, hub, db, cfg.Kafka.Brokers, kafka)
+	registerPlayerRoutes(mux, db)
+	registerReplayRoutes(mux, db)
+	registerExportRoutes(mux, db)
+	registerPositionRoutes(mux)
+	analyzer := NewAnalyzer(cfg.Analysis, db)
+	registerAnalysisRoutes(mux, db, analyzer)
+	registerLeaderboardRoutes(mux, cfg.API, db, auth)
+	registerStatsRoutes(mux, db, hub)
 
 	// ---------------- Recent Game Results ----------------
-	http.HandleFunc("/game_results", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/game_results", withRequestLog("game_results", func(w http.ResponseWriter, r *http.Request) {
+		reqLog := requestLogger(r, "game_results")
 		type GR struct {
 			GameID  string `json:"game_id"`
 			Player1 string `json:"player1"`
 			Player2 string `json:"player2"`
 			Winner  string `json:"winner"`
+			Outcome Result `json:"outcome"`
 			Moves   int64  `json:"moves"`
 			Date    string `json:"date"`
 		}
@@ -184,11 +126,11 @@ func main() {
 
 		opts := options.Find()
 		opts.SetSort(bson.D{{Key: "created_at", Value: -1}})
-		opts.SetLimit(50)
+		opts.SetLimit(int64(cfg.API.ResultsLimit))
 
 		cursor, err := coll.Find(ctx, bson.M{}, opts)
 		if err != nil {
-			log.Println("game_results query error:", err)
+			reqLog.Error("game_results query failed", "error", err)
 			writeJSON(w, results)
 			return
 		}
@@ -200,23 +142,62 @@ func main() {
 				Player1  string    `bson:"player1"`
 				Player2  string    `bson:"player2"`
 				Winner   string    `bson:"winner"`
+				Outcome  Result    `bson:"outcome"`
 				Moves    int64     `bson:"moves"`
 				CreatedAt time.Time `bson:"created_at"`
 			}
-			if err := cursor.Decode(&doc); err == nil {
-				results = append(results, GR{
-					GameID:  doc.GameID,
-					Player1: doc.Player1,
-					Player2: doc.Player2,
-					Winner:  doc.Winner,
-					Moves:   doc.Moves,
-					Date:    doc.CreatedAt.Format("2006-01-02 15:04:05"),
-				})
+			if err := cursor.Decode(&doc); err != nil {
+				reqLog.Warn("skipping undecodable row", "error", err)
+				continue
 			}
+			if doc.Outcome == ResultDraw {
+				doc.Winner = "draw"
+			}
+			results = append(results, GR{
+				GameID:  doc.GameID,
+				Player1: doc.Player1,
+				Player2: doc.Player2,
+				Winner:  doc.Winner,
+				Outcome: doc.Outcome,
+				Moves:   doc.Moves,
+				Date:    doc.CreatedAt.Format("2006-01-02 15:04:05"),
+			})
 		}
 		writeJSON(w, results)
-	})
+	}))
 
-	fmt.Println("🚀 Server running on :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	if cfg.Retention.Enabled {
+		go NewRetentionJob(cfg.Retention, db, hub, metrics).Run(jobsCtx)
+	}
+	go analyzer.Run(jobsCtx)
+
+	srv := &http.Server{Addr: cfg.Server.Addr, Handler: mux}
+	go func() {
+		slog.Info("server listening", "op", "startup", "addr", cfg.Server.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("server failed", "op", "startup", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+
+	drain := cfg.Server.ShutdownDrain
+	slog.Info("shutting down", "op", "shutdown", "drain", drain.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain+10*time.Second)
+	defer cancel()
+	stopJobs()
+	hub.Shutdown(ctx, drain)
+	CloseExternalEngines()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("HTTP shutdown failed", "op", "shutdown", "error", err)
+	}
+	if err := kafka.Close(); err != nil {
+		slog.Error("kafka close failed", "op", "shutdown", "error", err)
+	}
+	db.Disconnect()
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// MCTSConfig tunes a Monte Carlo tree search engine. Strength grows smoothly
+// with the number of iterations, which makes it the knob for difficulty.
+type MCTSConfig struct {
+	Iterations  int     // playouts per move, cut short by the time budget; 0 for as many as it allows
+	Workers     int     // trees searched in parallel, their root statistics merged
+	Heavy       bool    // playouts take wins and block fours instead of moving at random
+	Exploration float64 // UCT exploration constant, 0 for √2
+	Seed        uint64  // 0 seeds from the clock; fixed seeds with an iteration limit repeat exactly
+}
+
+// mctsEngine implements UCT. Each worker grows its own tree from the root
+// and the move visited most across all trees is played.
+type mctsEngine struct {
+	name string
+	cfg  MCTSConfig
+}
+
+// NewMCTSEngine returns an MCTS engine under name with cfg
+func NewMCTSEngine(name string, cfg MCTSConfig) BotEngine {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	if cfg.Exploration == 0 {
+		cfg.Exploration = math.Sqrt2
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = rand.Uint64()
+	}
+	return &mctsEngine{name: name, cfg: cfg}
+}
+
+func (e *mctsEngine) Name() string { return e.name }
+
+func (e *mctsEngine) ChooseMove(pos Position, budget time.Duration) int {
+	deadline := time.Now().Add(budget)
+	perWorker := 0
+	if e.cfg.Iterations > 0 {
+		perWorker = (e.cfg.Iterations + e.cfg.Workers - 1) / e.cfg.Workers
+	}
+	discs := uint64(newSearchBoard(pos).discs)
+
+	visits := make([][Cols]int, e.cfg.Workers)
+	var wg sync.WaitGroup
+	for w := 0; w < e.cfg.Workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			// Seeding by position and worker keeps a fixed seed repeatable
+			// however the moves before were chosen
+			rng := rand.New(rand.NewPCG(e.cfg.Seed, discs<<8|uint64(w)))
+			visits[w] = e.search(pos, rng, perWorker, deadline)
+		}(w)
+	}
+	wg.Wait()
+
+	var total [Cols]int
+	for _, v := range visits {
+		for c := range total {
+			total[c] += v[c]
+		}
+	}
+	best, most := -1, -1
+	for _, c := range searchOrder {
+		if pos.Board[0][c] == Empty && total[c] > most {
+			best, most = c, total[c]
+		}
+	}
+	return best
+}
+
+// mctsNode is a position in the tree, reached by playing move
+type mctsNode struct {
+	move     int
+	mover    Player // who played move
+	parent   *mctsNode
+	children []*mctsNode
+	untried  []int
+	visits   float64
+	score    float64 // for mover: 1 per win, 0.5 per draw
+	winner   Player  // set once the game is over here
+	terminal bool
+}
+
+// search runs up to iterations playouts, any number when 0, until deadline
+// and returns the root's visit count for each column
+func (e *mctsEngine) search(pos Position, rng *rand.Rand, iterations int, deadline time.Time) [Cols]int {
+	root := &mctsNode{move: -1, mover: opponent(pos.Turn)}
+	b := newSearchBoard(pos)
+	root.untried = legalMoves(b)
+
+	// The first iteration always runs so there is a move to play
+	for i := 0; i == 0 || (iterations == 0 || i < iterations) && time.Now().Before(deadline); i++ {
+		n := root
+		var path []int
+
+		// Selection
+		for len(n.untried) == 0 && len(n.children) > 0 {
+			n = n.bestChild(e.cfg.Exploration)
+			b.play(n.move)
+			path = append(path, n.move)
+		}
+
+		// Expansion
+		if !n.terminal && len(n.untried) > 0 {
+			j := rng.IntN(len(n.untried))
+			c := n.untried[j]
+			n.untried[j] = n.untried[len(n.untried)-1]
+			n.untried = n.untried[:len(n.untried)-1]
+
+			child := &mctsNode{move: c, mover: b.turn, parent: n}
+			if b.wins(c) {
+				child.terminal, child.winner = true, b.turn
+			}
+			b.play(c)
+			path = append(path, c)
+			if !child.terminal && b.discs == Rows*Cols {
+				child.terminal = true
+			}
+			if !child.terminal {
+				child.untried = legalMoves(b)
+			}
+			n.children = append(n.children, child)
+			n = child
+		}
+
+		// Simulation
+		winner := n.winner
+		if !n.terminal {
+			winner = e.playout(b, rng, &path)
+		}
+
+		// Backpropagation
+		for ; n != nil; n = n.parent {
+			n.visits++
+			switch winner {
+			case n.mover:
+				n.score++
+			case Empty:
+				n.score += 0.5
+			}
+		}
+		for k := len(path) - 1; k >= 0; k-- {
+			b.undo(path[k])
+		}
+	}
+
+	var visits [Cols]int
+	for _, ch := range root.children {
+		visits[ch.move] = int(ch.visits)
+	}
+	return visits
+}
+
+// bestChild picks the child with the highest upper confidence bound
+func (n *mctsNode) bestChild(exploration float64) *mctsNode {
+	var best *mctsNode
+	bestUCT := math.Inf(-1)
+	logN := math.Log(n.visits)
+	for _, ch := range n.children {
+		uct := ch.score/ch.visits + exploration*math.Sqrt(logN/ch.visits)
+		if uct > bestUCT {
+			best, bestUCT = ch, uct
+		}
+	}
+	return best
+}
+
+// playout finishes the game from b, appending the moves to path, and
+// returns the winner or Empty for a draw
+func (e *mctsEngine) playout(b *searchBoard, rng *rand.Rand, path *[]int) Player {
+	for b.discs < Rows*Cols {
+		moves := legalMoves(b)
+		c := moves[rng.IntN(len(moves))]
+		if e.cfg.Heavy {
+			if wins := b.winningColumns(); len(wins) > 0 {
+				c = wins[0]
+			} else {
+				b.turn = opponent(b.turn)
+				threats := b.winningColumns()
+				b.turn = opponent(b.turn)
+				if len(threats) > 0 {
+					c = threats[0]
+				}
+			}
+		}
+		mover := b.turn
+		won := b.wins(c)
+		b.play(c)
+		*path = append(*path, c)
+		if won {
+			return mover
+		}
+	}
+	return Empty
+}
+
+func legalMoves(b *searchBoard) []int {
+	moves := make([]int, 0, Cols)
+	for c := 0; c < Cols; c++ {
+		if b.canPlay(c) {
+			moves = append(moves, c)
+		}
+	}
+	return moves
+}
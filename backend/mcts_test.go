@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMCTSSeededRunsRepeat(t *testing.T) {
+	pos, err := ParsePosition("7/7/7/3o3/2xx3/1oxo3 x classic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := MCTSConfig{Iterations: 2000, Workers: 2, Heavy: true, Seed: 42}
+	first := NewMCTSEngine("mcts-test", cfg).ChooseMove(pos, time.Minute)
+	for i := 0; i < 3; i++ {
+		if got := NewMCTSEngine("mcts-test", cfg).ChooseMove(pos, time.Minute); got != first {
+			t.Fatalf("run %d chose column %d, first run chose %d", i+2, got, first)
+		}
+	}
+}
+
+func TestMCTSTakesForcedWin(t *testing.T) {
+	tests := []struct {
+		name  string
+		pos   string
+		heavy bool
+		want  int
+	}{
+		{"vertical, light playouts", "7/7/7/x6/xo5/xo1o3 x classic", false, 0},
+		{"horizontal, heavy playouts", "7/7/7/7/oo5/xxx1o2 x classic", true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos, err := ParsePosition(tt.pos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewMCTSEngine("mcts-test", MCTSConfig{Iterations: 3000, Heavy: tt.heavy, Seed: 7})
+			if got := e.ChooseMove(pos, time.Minute); got != tt.want {
+				t.Errorf("ChooseMove = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Game outcomes used as the outcome label of finished games
+const (
+	OutcomeWin     = "win"
+	OutcomeDraw    = "draw"
+	OutcomeForfeit = "forfeit"
+)
+
+// Metrics is everything the server records about itself. The hub and the
+// database only talk to this interface, so tests can pass a recorder and
+// assert on what was observed.
+type Metrics interface {
+	SetHubState(connections, waiting, games int)
+	GameStarted(bot bool)
+	GameFinished(outcome string, bot bool)
+	MoveProcessed(d time.Duration)
+	SendDropped()
+	DBCommand(name string, d time.Duration, failed bool)
+	RetentionRun(result string, aborted, archived int, d time.Duration)
+}
+
+// nopMetrics discards everything
+type nopMetrics struct{}
+
+func (nopMetrics) SetHubState(int, int, int)                    {}
+func (nopMetrics) GameStarted(bool)                             {}
+func (nopMetrics) GameFinished(string, bool)                    {}
+func (nopMetrics) MoveProcessed(time.Duration)                  {}
+func (nopMetrics) SendDropped()                                 {}
+func (nopMetrics) DBCommand(string, time.Duration, bool)        {}
+func (nopMetrics) RetentionRun(string, int, int, time.Duration) {}
+
+// PromMetrics exposes the server metrics in Prometheus format
+type PromMetrics struct {
+	registry     *prometheus.Registry
+	connections  prometheus.Gauge
+	waiting      prometheus.Gauge
+	liveGames    prometheus.Gauge
+	gamesStarted *prometheus.CounterVec
+	gamesEnded   *prometheus.CounterVec
+	moveLatency  prometheus.Histogram
+	sendDrops    prometheus.Counter
+	dbLatency    *prometheus.HistogramVec
+	dbErrors     *prometheus.CounterVec
+	retRuns      *prometheus.CounterVec
+	retAborted   prometheus.Counter
+	retArchived  prometheus.Counter
+	retDuration  prometheus.Gauge
+	retLastRun   prometheus.Gauge
+}
+
+func NewPromMetrics() *PromMetrics {
+	m := &PromMetrics{
+		registry: prometheus.NewRegistry(),
+		connections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fourinarow_ws_connections",
+			Help: "Open WebSocket connections that have joined.",
+		}),
+		waiting: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fourinarow_queue_length",
+			Help: "Players waiting in the matchmaking queue.",
+		}),
+		liveGames: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fourinarow_live_games",
+			Help: "Games currently being played.",
+		}),
+		gamesStarted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fourinarow_games_started_total",
+			Help: "Games started, by opponent type.",
+		}, []string{"opponent"}),
+		gamesEnded: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fourinarow_games_finished_total",
+			Help: "Games finished, by outcome and opponent type.",
+		}, []string{"outcome", "opponent"}),
+		moveLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "fourinarow_move_latency_seconds",
+			Help:    "Time from receiving a drop to broadcasting the move.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}),
+		sendDrops: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fourinarow_send_dropped_total",
+			Help: "Outgoing messages dropped because a client's send buffer was full.",
+		}),
+		dbLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fourinarow_db_command_seconds",
+			Help:    "MongoDB command latency, by command name.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"command"}),
+		dbErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fourinarow_db_command_errors_total",
+			Help: "Failed MongoDB commands, by command name.",
+		}, []string{"command"}),
+		retRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fourinarow_retention_runs_total",
+			Help: "Retention job runs, by result (ok, error or dry_run).",
+		}, []string{"result"}),
+		retAborted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fourinarow_retention_games_aborted_total",
+			Help: "Abandoned unfinished games marked aborted.",
+		}),
+		retArchived: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "fourinarow_retention_games_archived_total",
+			Help: "Finished games moved from MongoDB to archive files.",
+		}),
+		retDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fourinarow_retention_last_run_seconds",
+			Help: "How long the last retention run took.",
+		}),
+		retLastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fourinarow_retention_last_success_timestamp_seconds",
+			Help: "Unix time of the last retention run that succeeded.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connections, m.waiting, m.liveGames,
+		m.gamesStarted, m.gamesEnded, m.moveLatency, m.sendDrops,
+		m.dbLatency, m.dbErrors,
+		m.retRuns, m.retAborted, m.retArchived, m.retDuration, m.retLastRun,
+	)
+	return m
+}
+
+// Handler serves the /metrics endpoint
+func (m *PromMetrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *PromMetrics) SetHubState(connections, waiting, games int) {
+	m.connections.Set(float64(connections))
+	m.waiting.Set(float64(waiting))
+	m.liveGames.Set(float64(games))
+}
+
+func (m *PromMetrics) GameStarted(bot bool) {
+	m.gamesStarted.WithLabelValues(opponentLabel(bot)).Inc()
+}
+
+func (m *PromMetrics) GameFinished(outcome string, bot bool) {
+	m.gamesEnded.WithLabelValues(outcome, opponentLabel(bot)).Inc()
+}
+
+func (m *PromMetrics) MoveProcessed(d time.Duration) {
+	m.moveLatency.Observe(d.Seconds())
+}
+
+func (m *PromMetrics) SendDropped() {
+	m.sendDrops.Inc()
+}
+
+func (m *PromMetrics) DBCommand(name string, d time.Duration, failed bool) {
+	m.dbLatency.WithLabelValues(name).Observe(d.Seconds())
+	if failed {
+		m.dbErrors.WithLabelValues(name).Inc()
+	}
+}
+
+func (m *PromMetrics) RetentionRun(result string, aborted, archived int, d time.Duration) {
+	m.retRuns.WithLabelValues(result).Inc()
+	m.retDuration.Set(d.Seconds())
+	if result == "ok" {
+		m.retAborted.Add(float64(aborted))
+		m.retArchived.Add(float64(archived))
+		m.retLastRun.SetToCurrentTime()
+	}
+}
+
+func opponentLabel(bot bool) string {
+	if bot {
+		return "bot"
+	}
+	return "human"
+}
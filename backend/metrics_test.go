@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics keeps what the hub reports so tests can check it
+type recordingMetrics struct {
+	mu       sync.Mutex
+	started  []bool
+	finished []string
+	vsBot    []bool
+	hubState [3]int
+	drops    int
+}
+
+func (m *recordingMetrics) SetHubState(connections, waiting, games int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hubState = [3]int{connections, waiting, games}
+}
+
+func (m *recordingMetrics) GameStarted(bot bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.started = append(m.started, bot)
+}
+
+func (m *recordingMetrics) GameFinished(outcome string, bot bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.finished = append(m.finished, outcome)
+	m.vsBot = append(m.vsBot, bot)
+}
+
+func (m *recordingMetrics) SendDropped() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drops++
+}
+
+func (m *recordingMetrics) MoveProcessed(time.Duration)                  {}
+func (m *recordingMetrics) DBCommand(string, time.Duration, bool)        {}
+func (m *recordingMetrics) RetentionRun(string, int, int, time.Duration) {}
+
+func newTestClient(name string, buffer int) *WSClient {
+	return &WSClient{Username: name, PlayerID: name, Send: make(chan []byte, buffer), log: slog.Default()}
+}
+
+// newTestHub returns a hub without a database or Kafka whose players never
+// fall back to a bot
+func newTestHub(m Metrics) *Hub {
+	cfg := DefaultConfig().Game
+	cfg.BotFallback = time.Hour
+	return NewHub(cfg, nil, nil, m, nil)
+}
+
+func TestMetricsRecordGames(t *testing.T) {
+	tests := []struct {
+		name    string
+		moves   []int // alternating, alice first
+		leaver  string
+		outcome string
+	}{
+		{"win", []int{0, 1, 0, 1, 0, 1, 0}, "", OutcomeWin},
+		{"forfeit", []int{3, 3}, "bob", OutcomeForfeit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &recordingMetrics{}
+			h := newTestHub(m)
+			alice, bob := newTestClient("alice", 64), newTestClient("bob", 64)
+			h.addToQueue(alice)
+			h.addToQueue(bob)
+			if m.hubState != [3]int{0, 0, 1} {
+				t.Errorf("hub state after the match = %v, want one game", m.hubState)
+			}
+
+			for i, col := range tt.moves {
+				player := alice
+				if i%2 == 1 {
+					player = bob
+				}
+				h.handleDrop(player, col)
+			}
+			if tt.leaver == "bob" {
+				h.handleDisconnect(bob)
+			}
+
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			if len(m.started) != 1 || m.started[0] {
+				t.Errorf("games started = %v, want one against a person", m.started)
+			}
+			if len(m.finished) != 1 || m.finished[0] != tt.outcome || m.vsBot[0] {
+				t.Errorf("games finished = %v (vs bot %v), want one %s against a person", m.finished, m.vsBot, tt.outcome)
+			}
+			if m.hubState[2] != 0 {
+				t.Errorf("live games = %d after the game ended", m.hubState[2])
+			}
+		})
+	}
+}
+
+func TestMetricsRecordSendDrops(t *testing.T) {
+	m := &recordingMetrics{}
+	h := newTestHub(m)
+	c := newTestClient("alice", 1)
+	h.sendJSON(c, WSMessage{Type: "waiting"})
+	h.sendJSON(c, WSMessage{Type: "waiting"})
+	if m.drops != 1 {
+		t.Errorf("drops = %d, want 1 once the buffer is full", m.drops)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is one versioned change to the database. Up must be safe to run
+// again if it was interrupted before being recorded.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *MongoDB) error
+}
+
+// migrations are applied in order. Never renumber or edit one that has been
+// released; add a new one instead.
+var migrations = []Migration{
+	{1, "backfill_result_outcomes", backfillResultOutcomes},
+	{2, "backfill_result_flags", backfillResultFlags},
+	{3, "create_indexes", createIndexes},
+	{4, "rebuild_stats", func(ctx context.Context, db *MongoDB) error { return db.RebuildStats(ctx) }},
+	{5, "retention_indexes", createRetentionIndexes},
+	{6, "bot_account_indexes", createBotAccountIndexes},
+	{7, "rebuild_username_skeletons", rebuildUsernameSkeletons},
+}
+
+// AppliedMigration is a row of schema_migrations
+type AppliedMigration struct {
+	Version   int           `bson:"_id"`
+	Name      string        `bson:"name"`
+	AppliedAt time.Time     `bson:"applied_at"`
+	Took      time.Duration `bson:"took"`
+}
+
+// AppliedMigrations returns the recorded migrations by version
+func (db *MongoDB) AppliedMigrations(ctx context.Context) (map[int]AppliedMigration, error) {
+	cursor, err := db.Database.Collection("schema_migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var rows []AppliedMigration
+	if err := cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]AppliedMigration, len(rows))
+	for _, m := range rows {
+		applied[m.Version] = m
+	}
+	return applied, nil
+}
+
+// PendingMigrations returns the migrations not applied yet, in order
+func (db *MongoDB) PendingMigrations(ctx context.Context) ([]Migration, error) {
+	applied, err := db.AppliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration in order and stops at the first
+// failure. Only one process should migrate at a time.
+func (db *MongoDB) Migrate(ctx context.Context) error {
+	pending, err := db.PendingMigrations(ctx)
+	if err != nil {
+		return fmt.Errorf("reading schema_migrations: %w", err)
+	}
+	for _, m := range pending {
+		log := slog.With("op", "migrate", "version", m.Version, "name", m.Name)
+		log.Info("applying migration")
+		start := time.Now()
+		if err := m.Up(ctx, db); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		_, err := db.Database.Collection("schema_migrations").InsertOne(ctx, AppliedMigration{
+			Version:   m.Version,
+			Name:      m.Name,
+			AppliedAt: time.Now(),
+			Took:      time.Since(start),
+		})
+		if err != nil {
+			return fmt.Errorf("recording migration %d: %w", m.Version, err)
+		}
+		log.Info("migration applied", "took", time.Since(start).String())
+	}
+	return nil
+}
+
+// backfillResultOutcomes converts game results stored before outcomes and
+// player IDs existed. Old rows marked draws with the winner "draw"; players
+// had no accounts then, so their names double as their IDs.
+func backfillResultOutcomes(ctx context.Context, db *MongoDB) error {
+	isDraw := bson.M{"$eq": bson.A{"$winner", "draw"}}
+	res, err := db.Database.Collection("game_results").UpdateMany(ctx,
+		bson.M{"outcome": bson.M{"$exists": false}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"outcome": bson.M{"$switch": bson.M{
+					"branches": bson.A{
+						bson.M{"case": isDraw, "then": ResultDraw},
+						bson.M{"case": bson.M{"$eq": bson.A{"$winner", "$player1"}}, "then": ResultP1Win},
+					},
+					"default": ResultP2Win,
+				}},
+				"player1_id": "$player1",
+				"player2_id": "$player2",
+				"winner_id":  bson.M{"$cond": bson.A{isDraw, "", "$winner"}},
+				"winner":     bson.M{"$cond": bson.A{isDraw, "", "$winner"}},
+				"forfeit":    false,
+			}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	slog.Info("backfilled result outcomes", "op", "migrate", "results", res.ModifiedCount)
+	return nil
+}
+
+// backfillResultFlags fills in the filter fields leaderboards match on for
+// results stored before they existed. Guests could not play back then, so
+// those games were all rated.
+func backfillResultFlags(ctx context.Context, db *MongoDB) error {
+	coll := db.Database.Collection("game_results")
+	bots := bson.A{BotID, BotName}
+	updates := []struct {
+		field string
+		value interface{}
+	}{
+		{"rated", true},
+		{"variant", VariantClassic},
+		{"vs_bot", bson.M{"$or": bson.A{
+			bson.M{"$in": bson.A{"$player1_id", bots}},
+			bson.M{"$in": bson.A{"$player2_id", bots}},
+		}}},
+	}
+	for _, u := range updates {
+		res, err := coll.UpdateMany(ctx,
+			bson.M{u.field: bson.M{"$exists": false}},
+			mongo.Pipeline{{{Key: "$set", Value: bson.M{u.field: u.value}}}},
+		)
+		if err != nil {
+			return fmt.Errorf("backfilling %s: %w", u.field, err)
+		}
+		slog.Info("backfilled result field", "op", "migrate", "field", u.field, "results", res.ModifiedCount)
+	}
+	return nil
+}
+
+// createIndexes creates the indexes every query relies on. A game is stored
+// once in each collection, so game_id is unique in both; the migration fails
+// if existing data has duplicates, which then need to be resolved by hand.
+func createIndexes(ctx context.Context, db *MongoDB) error {
+	// Earlier versions created a plain game_id index on games at startup
+	// under the name the unique one would get.
+	_, err := db.Database.Collection("games").Indexes().DropOne(ctx, "game_id_1")
+	if err != nil && !isNotFound(err) {
+		return fmt.Errorf("dropping old games index: %w", err)
+	}
+
+	unique := options.Index().SetUnique(true)
+	indexes := []struct {
+		coll   string
+		models []mongo.IndexModel
+	}{
+		{"games", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "game_id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "suspended", Value: 1}, {Key: "updated_at", Value: -1}}},
+		}},
+		{"game_results", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "game_id", Value: 1}}, Options: unique},
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "winner_id", Value: 1}}},
+			{Keys: bson.D{{Key: "player1_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "player2_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "player1_id", Value: 1}, {Key: "player2_id", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "rated", Value: 1}, {Key: "variant", Value: 1}, {Key: "created_at", Value: -1}}},
+		}},
+		{"player_stats", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "username", Value: 1}}},
+		}},
+		{"users", []mongo.IndexModel{
+			{Keys: bson.D{{Key: "skeleton", Value: 1}}, Options: unique},
+		}},
+	}
+	for _, ix := range indexes {
+		if _, err := db.Database.Collection(ix.coll).Indexes().CreateMany(ctx, ix.models); err != nil {
+			return fmt.Errorf("indexing %s: %w", ix.coll, err)
+		}
+	}
+	return nil
+}
+
+// createRetentionIndexes supports the retention job's scans of games by
+// state and age
+func createRetentionIndexes(ctx context.Context, db *MongoDB) error {
+	_, err := db.Database.Collection("games").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "finished", Value: 1}, {Key: "updated_at", Value: 1}},
+	})
+	return err
+}
+
+// createBotAccountIndexes makes API keys unique and quick to look up. Only
+// bot accounts have one, so the index is sparse.
+func createBotAccountIndexes(ctx context.Context, db *MongoDB) error {
+	_, err := db.Database.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "api_key_hash", Value: 1}},
+		Options: options.Index().SetUnique(true).SetSparse(true),
+	})
+	return err
+}
+
+// rebuildUsernameSkeletons recomputes every user's skeleton after the
+// skeleton started folding case before lookalikes. Accounts that now collide
+// with another keep their old skeleton and are logged; Login still finds
+// them by their exact username, which gets an index for that.
+func rebuildUsernameSkeletons(ctx context.Context, db *MongoDB) error {
+	users := db.Database.Collection("users")
+	if _, err := users.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: bson.D{{Key: "username", Value: 1}}}); err != nil {
+		return fmt.Errorf("indexing users: %w", err)
+	}
+	cursor, err := users.Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"username": 1, "skeleton": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	updated, clashes := 0, 0
+	for cursor.Next(ctx) {
+		var u User
+		if err := cursor.Decode(&u); err != nil {
+			return err
+		}
+		skel := usernameSkeleton(u.Username)
+		if skel == u.Skeleton {
+			continue
+		}
+		_, err := users.UpdateOne(ctx, bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"skeleton": skel}})
+		switch {
+		case mongo.IsDuplicateKeyError(err):
+			clashes++
+			slog.Warn("username now looks like another, keeping its old skeleton", "op", "migrate", "username", u.Username, "skeleton", skel)
+		case err != nil:
+			return err
+		default:
+			updated++
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	slog.Info("rebuilt username skeletons", "op", "migrate", "updated", updated, "clashes", clashes)
+	return nil
+}
+
+// isNotFound reports whether err says the index or collection is missing
+func isNotFound(err error) bool {
+	var ce mongo.CommandError
+	if errors.As(err, &ce) {
+		return ce.Code == 26 || ce.Code == 27 // NamespaceNotFound, IndexNotFound
+	}
+	return false
+}
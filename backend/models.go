@@ -12,21 +12,91 @@ type GameDB struct {
 	GameID    string              `bson:"game_id"`
 	Player1   string              `bson:"player1"`
 	Player2   string              `bson:"player2"`
+	Player1ID string              `bson:"player1_id"`
+	Player2ID string              `bson:"player2_id"`
 	StartedAt time.Time           `bson:"started_at"`
 	Finished  bool                `bson:"finished"`
 	Winner    string              `bson:"winner"`
+	Outcome   Result              `bson:"outcome,omitempty"`
+	Suspended bool                `bson:"suspended,omitempty"`
+	Aborted   bool                `bson:"aborted,omitempty"` // abandoned, see RetentionJob
+	Imported  bool                `bson:"imported,omitempty"` // read from notation, see ImportGame
+	MoveLog   []int               `bson:"move_log,omitempty"`
+	Start     string              `bson:"start_position,omitempty"` // see ParsePosition, "" for the empty board
+	Hints     int                 `bson:"hints,omitempty"`
+	BotEngine string              `bson:"bot_engine,omitempty"` // engine of the bot player, see BotEngine
+	VsBot     bool                `bson:"vs_bot,omitempty"`     // a server bot or a bot account played
 	CreatedAt time.Time           `bson:"created_at"`
 	UpdatedAt time.Time           `bson:"updated_at"`
 }
 
-// GameResult represents a finished game result
+// GameResult represents a finished game result. Player IDs are user IDs for
+// registered players and the full name for guests and bots.
 type GameResult struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
 	GameID    string              `bson:"game_id"`
 	Player1   string              `bson:"player1"`
 	Player2   string              `bson:"player2"`
-	Winner    string              `bson:"winner"`
+	Player1ID string              `bson:"player1_id"`
+	Player2ID string              `bson:"player2_id"`
+	Winner    string              `bson:"winner"`    // winner's username, empty for a draw
+	WinnerID  string              `bson:"winner_id"` // empty for a draw
+	Outcome   Result              `bson:"outcome"`
+	Forfeit   bool                `bson:"forfeit"`
 	Moves     int                 `bson:"moves"`
 	Duration  time.Duration       `bson:"duration"`
+	Rated     bool                `bson:"rated"` // false when a guest took part or hints were used
+	Hints     int                 `bson:"hints,omitempty"`
+	VsBot     bool                `bson:"vs_bot"` // a server bot or a bot account played
+	BotEngine string              `bson:"bot_engine,omitempty"`
+	Variant   string              `bson:"variant"`
 	CreatedAt time.Time           `bson:"created_at"`
 }
+
+// PlayerStats holds a player's running totals, keyed by player ID and
+// updated as each game finishes
+type PlayerStats struct {
+	PlayerID      string    `bson:"_id"`
+	Username      string    `bson:"username"`
+	Games         int       `bson:"games"`
+	Wins          int       `bson:"wins"`
+	Losses        int       `bson:"losses"`
+	Draws         int       `bson:"draws"`
+	Forfeits      int       `bson:"forfeits"` // games lost by leaving
+	GamesAsP1     int       `bson:"games_as_p1"`
+	WinsAsP1      int       `bson:"wins_as_p1"`
+	GamesAsP2     int       `bson:"games_as_p2"`
+	WinsAsP2      int       `bson:"wins_as_p2"`
+	WinMoves      int       `bson:"win_moves"` // total moves across won games
+	CurrentStreak int       `bson:"current_streak"`
+	BestStreak    int       `bson:"best_streak"`
+	Rating        float64   `bson:"rating"`
+	RatedGames    int       `bson:"rated_games"`
+	RatingVersion int       `bson:"rating_version"` // bumped on each rating change, see recordRatings
+	LastPlayedAt  time.Time `bson:"last_played_at"`
+}
+
+// SiteTotals are the counters behind /stats, kept in a single document and
+// incremented as each game finishes
+type SiteTotals struct {
+	ID         string        `bson:"_id"`
+	Games      int64         `bson:"games"`
+	Draws      int64         `bson:"draws"`
+	BotGames   int64         `bson:"bot_games"`
+	Forfeits   int64         `bson:"forfeits"`
+	DurationNS time.Duration `bson:"duration_ns"` // sum over all games
+	UpdatedAt  time.Time     `bson:"updated_at"`
+}
+
+// User is a registered account. Bot accounts have no password; they
+// connect with an API key and belong to the user who registered them.
+type User struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty"`
+	Username     string              `bson:"username"`
+	Skeleton     string              `bson:"skeleton"` // unique, see usernameSkeleton
+	PasswordHash string              `bson:"password_hash"`
+	Bot          bool                `bson:"bot,omitempty"`
+	OwnerID      string              `bson:"owner_id,omitempty"`     // user ID of a bot's owner
+	APIKeyHash   string              `bson:"api_key_hash,omitempty"` // unique, see hashAPIKey
+	CreatedAt    time.Time           `bson:"created_at"`
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Games are exported in a PGN-like text format. Each game is a block of tag
+// lines followed by a blank line and the move text:
+//
+//	[Event "fourinarow"]
+//	[GameID "5b0c..."]
+//	[Date "2026.10.19"]
+//	[Player1 "alice"]
+//	[Player2 "BOT"]
+//	[Result "1-0"]
+//	[Variant "classic"]
+//	[TimeControl "-"]
+//
+//	4453423 1-0
+//
+// Moves are the columns played, numbered 1 to 7 from the left, one digit per
+// move with player 1 moving first; whitespace between moves is ignored. The
+// move text ends with the result: 1-0 (player 1 won), 0-1 (player 2 won),
+// 1/2-1/2 (draw) or * (unfinished). A Termination tag of "forfeit" marks a
+// game decided by a player leaving rather than on the board, and "abandoned"
+// one that was never finished. An unknown date is written as ????.??.??.
+// A game that began from a set position rather than the empty board carries
+// it in a Position tag, see ParsePosition. Files may hold several games
+// separated by blank lines. Unknown tags are ignored on import.
+
+// GameRecord is one game in portable notation. Moves are 0-based columns.
+type GameRecord struct {
+	GameID      string
+	Date        time.Time
+	Player1     string
+	Player2     string
+	Result      Result // ResultNone for "*"
+	Variant     string
+	TimeControl string
+	Termination string // "", "forfeit" or "abandoned"
+	Position    string // start position, "" for the empty board
+	Moves       []int
+}
+
+const (
+	TerminationForfeit   = "forfeit"
+	TerminationAbandoned = "abandoned"
+)
+
+var resultTokens = map[Result]string{
+	ResultNone:  "*",
+	ResultP1Win: "1-0",
+	ResultP2Win: "0-1",
+	ResultDraw:  "1/2-1/2",
+}
+
+func parseResultToken(s string) (Result, bool) {
+	for r, tok := range resultTokens {
+		if tok == s {
+			return r, true
+		}
+	}
+	return ResultNone, false
+}
+
+// WriteGame writes rec in portable notation
+func WriteGame(w io.Writer, rec GameRecord) error {
+	date := "????.??.??"
+	if !rec.Date.IsZero() {
+		date = rec.Date.UTC().Format("2006.01.02")
+	}
+	tags := [][2]string{
+		{"Event", "fourinarow"},
+		{"GameID", rec.GameID},
+		{"Date", date},
+		{"Player1", rec.Player1},
+		{"Player2", rec.Player2},
+		{"Result", resultTokens[rec.Result]},
+		{"Variant", rec.Variant},
+		{"TimeControl", rec.TimeControl},
+	}
+	if rec.Termination != "" {
+		tags = append(tags, [2]string{"Termination", rec.Termination})
+	}
+	if rec.Position != "" {
+		tags = append(tags, [2]string{"Position", rec.Position})
+	}
+
+	var b strings.Builder
+	for _, t := range tags {
+		fmt.Fprintf(&b, "[%s %s]\n", t[0], strconv.Quote(t[1]))
+	}
+	b.WriteByte('\n')
+	for _, col := range rec.Moves {
+		b.WriteByte(byte('1' + col))
+	}
+	if len(rec.Moves) > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(resultTokens[rec.Result])
+	b.WriteString("\n\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// ReadGames parses every game in r. Errors name the line they were found on.
+func ReadGames(r io.Reader) ([]GameRecord, error) {
+	var (
+		games   []GameRecord
+		cur     *GameRecord
+		inMoves bool
+		lineNo  int
+	)
+	fail := func(format string, args ...interface{}) ([]GameRecord, error) {
+		return nil, fmt.Errorf("line %d: %s", lineNo, fmt.Sprintf(format, args...))
+	}
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == "":
+			continue
+
+		case strings.HasPrefix(line, "["):
+			if cur == nil || inMoves {
+				if cur != nil {
+					return fail("game %q has no result at the end of its moves", cur.GameID)
+				}
+				games = append(games, GameRecord{Variant: VariantClassic, TimeControl: "-"})
+				cur, inMoves = &games[len(games)-1], false
+			}
+			name, value, err := parseTag(line)
+			if err != nil {
+				return fail("%v", err)
+			}
+			if err := cur.setTag(name, value); err != nil {
+				return fail("%v", err)
+			}
+
+		default:
+			if cur == nil {
+				return fail("moves before any tags")
+			}
+			inMoves = true
+			done, err := cur.addMoveText(line)
+			if err != nil {
+				return fail("%v", err)
+			}
+			if done {
+				cur, inMoves = nil, false
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if cur != nil {
+		return fail("game %q has no result at the end of its moves", cur.GameID)
+	}
+	return games, nil
+}
+
+// parseTag splits a [Name "value"] line
+func parseTag(line string) (string, string, error) {
+	if !strings.HasSuffix(line, "]") {
+		return "", "", errors.New("tag is missing its closing bracket")
+	}
+	name, quoted, ok := strings.Cut(line[1:len(line)-1], " ")
+	if !ok || name == "" {
+		return "", "", errors.New(`tag must look like [Name "value"]`)
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(quoted))
+	if err != nil {
+		return "", "", fmt.Errorf("tag %s: value must be a quoted string", name)
+	}
+	return name, value, nil
+}
+
+func (rec *GameRecord) setTag(name, value string) error {
+	switch name {
+	case "GameID":
+		rec.GameID = value
+	case "Date":
+		d, err := time.Parse("2006.01.02", value)
+		if err != nil && value != "????.??.??" {
+			return fmt.Errorf("Date %q is not YYYY.MM.DD", value)
+		}
+		rec.Date = d
+	case "Player1":
+		rec.Player1 = value
+	case "Player2":
+		rec.Player2 = value
+	case "Result":
+		res, ok := parseResultToken(value)
+		if !ok {
+			return fmt.Errorf("unknown result %q", value)
+		}
+		rec.Result = res
+	case "Variant":
+		rec.Variant = value
+	case "TimeControl":
+		rec.TimeControl = value
+	case "Termination":
+		rec.Termination = value
+	case "Position":
+		rec.Position = value
+	}
+	return nil
+}
+
+// addMoveText consumes one line of move text and reports whether it ended
+// with the result
+func (rec *GameRecord) addMoveText(line string) (bool, error) {
+	for i, field := range strings.Fields(line) {
+		if res, ok := parseResultToken(field); ok {
+			if rest := strings.Fields(line)[i+1:]; len(rest) > 0 {
+				return false, fmt.Errorf("unexpected %q after the result", rest[0])
+			}
+			if res != rec.Result {
+				return false, fmt.Errorf("move text ends in %s but the Result tag says %s", field, resultTokens[rec.Result])
+			}
+			return true, nil
+		}
+		for _, ch := range field {
+			if ch < '1' || ch > '0'+Cols {
+				return false, fmt.Errorf("%q is not a column between 1 and %d", ch, Cols)
+			}
+			rec.Moves = append(rec.Moves, int(ch-'1'))
+		}
+	}
+	return false, nil
+}
+
+// Rebuild replays the moves with Drop and checks that they are legal and
+// agree with the recorded result
+func (rec GameRecord) Rebuild() (*GameLogic, error) {
+	if rec.Variant != VariantClassic {
+		return nil, fmt.Errorf("unsupported variant %q", rec.Variant)
+	}
+	if rec.Player1 == "" || rec.Player2 == "" {
+		return nil, errors.New("Player1 and Player2 tags are required")
+	}
+
+	g := NewGame(rec.GameID, rec.Player1, rec.Player2)
+	if rec.Position != "" {
+		pos, err := ParsePosition(rec.Position)
+		if err != nil {
+			return nil, fmt.Errorf("Position: %w", err)
+		}
+		if pos.Variant != rec.Variant {
+			return nil, fmt.Errorf("Position is for %s but the Variant tag says %s", pos.Variant, rec.Variant)
+		}
+		g = NewGameFrom(rec.GameID, rec.Player1, rec.Player2, pos)
+	}
+	if !rec.Date.IsZero() {
+		g.StartedAt = rec.Date
+	}
+	for i, col := range rec.Moves {
+		if _, err := g.Drop(col, g.CurrentPlayerName()); err != nil {
+			return nil, fmt.Errorf("move %d (column %d): %w", i+1, col+1, err)
+		}
+	}
+
+	switch {
+	case rec.Termination == TerminationForfeit:
+		if g.Finished {
+			return nil, errors.New("game was already decided on the board, it cannot end in a forfeit")
+		}
+		switch rec.Result {
+		case ResultP1Win:
+			g.Forfeit(P2)
+		case ResultP2Win:
+			g.Forfeit(P1)
+		default:
+			return nil, errors.New("a forfeit needs a winner")
+		}
+	case g.Result != rec.Result:
+		return nil, fmt.Errorf("moves end in %s but the result is %s", resultTokens[g.Result], resultTokens[rec.Result])
+	}
+	return g, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Events a bot can emote on
+const (
+	EmoteStart = "start" // the game begins
+	EmoteAhead = "ahead" // the bot first sees a forced win
+	EmoteWin   = "win"
+	EmoteLoss  = "loss"
+	EmoteDraw  = "draw"
+)
+
+var emoteEvents = []string{EmoteStart, EmoteAhead, EmoteWin, EmoteLoss, EmoteDraw}
+
+// Moves scoring within closeMargin of the best count as real options when
+// judging how complex a position is. Positions are judged by a search of
+// analysisDepth plies, which is cheap next to any engine's own.
+const (
+	closeMargin   = 8
+	analysisDepth = 4
+)
+
+// thinkSpread is the standard deviation of the log of a think time; about
+// two in three moves take between 0.7 and 1.4 times the mean
+const thinkSpread = 0.35
+
+// PersonalityConfig is how a bot behaves at the table, as opposed to how
+// well its engine plays. The zero value thinks for the fixed bot move delay,
+// never errs on purpose and says nothing.
+type PersonalityConfig struct {
+	ThinkMin    time.Duration       `yaml:"think_min"`    // think time on forced moves and the floor for the rest
+	ThinkMean   time.Duration       `yaml:"think_mean"`   // typical think time, longer when many moves are close; 0 for the bot move delay
+	ThinkMax    time.Duration       `yaml:"think_max"`    // ceiling on think time, 0 for none
+	ErrorRate   float64             `yaml:"error_rate"`   // chance of playing another move that does not lose outright, on unforced moves
+	Attack      float64             `yaml:"attack"`       // weight of the bot's own open lines, 0 for 1
+	Defense     float64             `yaml:"defense"`      // weight of the opponent's open lines, 0 for 1
+	Emotes      map[string][]string `yaml:"emotes"`       // lines to say by event, see emoteEvents
+	EmoteChance float64             `yaml:"emote_chance"` // chance of saying one on each event, 0 for every time
+}
+
+// Style returns the evaluation style of engines that take one
+func (c PersonalityConfig) Style() Style {
+	return Style{Attack: c.Attack, Defense: c.Defense}
+}
+
+// defaultPersonalities gives each built-in engine a pace that roughly fits
+// its strength. The easy levels slip up now and then and minimax-easy plays
+// for its own lines at the expense of blocking.
+func defaultPersonalities() map[string]PersonalityConfig {
+	return map[string]PersonalityConfig{
+		"heuristic":      {ThinkMin: 250 * time.Millisecond, ThinkMean: 700 * time.Millisecond, ThinkMax: 2 * time.Second},
+		"random":         {ThinkMin: 200 * time.Millisecond, ThinkMean: 500 * time.Millisecond, ThinkMax: 1500 * time.Millisecond},
+		"minimax-easy":   {ThinkMin: 300 * time.Millisecond, ThinkMean: 900 * time.Millisecond, ThinkMax: 2500 * time.Millisecond, ErrorRate: 0.15, Attack: 1.5, Defense: 0.6},
+		"minimax-medium": {ThinkMin: 300 * time.Millisecond, ThinkMean: 1200 * time.Millisecond, ThinkMax: 3 * time.Second, ErrorRate: 0.05},
+		"minimax-hard":   {ThinkMin: 400 * time.Millisecond, ThinkMean: 1500 * time.Millisecond, ThinkMax: 4 * time.Second},
+		"solver":         {ThinkMin: 200 * time.Millisecond, ThinkMean: 800 * time.Millisecond, ThinkMax: 2 * time.Second},
+		"mcts-easy":      {ThinkMin: 300 * time.Millisecond, ThinkMean: 900 * time.Millisecond, ThinkMax: 2500 * time.Millisecond, ErrorRate: 0.15},
+		"mcts-medium":    {ThinkMin: 300 * time.Millisecond, ThinkMean: 1200 * time.Millisecond, ThinkMax: 3 * time.Second, ErrorRate: 0.05},
+		"mcts-hard":      {ThinkMin: 400 * time.Millisecond, ThinkMean: 1500 * time.Millisecond, ThinkMax: 4 * time.Second},
+	}
+}
+
+// Clock tells the time and waits. Bots pace themselves by it so tests can
+// swap in one that does not really sleep.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Personality plays a bot's moves in one game according to its config. It
+// is safe for concurrent use, since the game can end while the bot thinks.
+type Personality struct {
+	cfg   PersonalityConfig
+	delay time.Duration // think time when cfg has no ThinkMean
+	clock Clock
+
+	mu    sync.Mutex
+	rng   *rand.Rand
+	ahead bool // the ahead emote has had its chance
+}
+
+// NewPersonality returns a personality for one game. delay is the fixed
+// think time used when cfg sets none; all randomness comes from rng.
+func NewPersonality(cfg PersonalityConfig, delay time.Duration, clock Clock, rng *rand.Rand) *Personality {
+	return &Personality{cfg: cfg, delay: delay, clock: clock, rng: rng}
+}
+
+// positionSummary is what the personality needs to know about a position
+type positionSummary struct {
+	scores  map[int]int
+	best    int
+	forced  bool    // one legal move, a win on the board or a single move that does not lose
+	options float64 // share of the other legal moves that come close to the best, 0 to 1
+}
+
+func summarizePosition(pos Position) positionSummary {
+	s := positionSummary{scores: ScoreMoves(pos, analysisDepth)}
+	_, s.best, _ = BestMove(s.scores)
+	near, safe := 0, 0
+	for _, score := range s.scores {
+		if s.best-score <= closeMargin {
+			near++
+		}
+		if !IsForcedLoss(score) {
+			safe++
+		}
+	}
+	s.forced = len(s.scores) == 1 || s.best == WinScore-1 || safe == 1
+	if len(s.scores) > 1 {
+		s.options = float64(near-1) / float64(len(s.scores)-1)
+	}
+	return s
+}
+
+// Move asks e for a move in pos, perhaps swaps it for a mistake and waits
+// until the think time has passed, counting the time e took. emote is a
+// line to say along with the move, usually "".
+func (p *Personality) Move(e BotEngine, pos Position, budget time.Duration) (col int, emote string) {
+	start := p.clock.Now()
+	col = e.ChooseMove(pos, budget)
+	think := p.delay
+	if p.cfg.ThinkMean > 0 || p.cfg.ErrorRate > 0 || len(p.cfg.Emotes[EmoteAhead]) > 0 {
+		s := summarizePosition(pos)
+		p.mu.Lock()
+		col = p.mistake(s, col)
+		if p.cfg.ThinkMean > 0 {
+			think = p.thinkTime(s)
+		}
+		if !p.ahead && IsForcedWin(s.best) {
+			p.ahead = true
+			emote = p.emote(EmoteAhead)
+		}
+		p.mu.Unlock()
+	}
+	if wait := think - p.clock.Now().Sub(start); wait > 0 {
+		p.clock.Sleep(wait)
+	}
+	return col, emote
+}
+
+// mistake returns col, or with the error rate on an unforced move another
+// move that does not lose outright. Caller must hold p.mu.
+func (p *Personality) mistake(s positionSummary, col int) int {
+	if s.forced || p.cfg.ErrorRate == 0 || p.rng.Float64() >= p.cfg.ErrorRate {
+		return col
+	}
+	var others []int
+	for _, c := range searchOrder {
+		if score, ok := s.scores[c]; ok && c != col && !IsForcedLoss(score) {
+			others = append(others, c)
+		}
+	}
+	if len(others) == 0 {
+		return col
+	}
+	return others[p.rng.IntN(len(others))]
+}
+
+// thinkTime draws a think time: the minimum on forced moves, otherwise
+// log-normal around a mean stretched by how many moves are worth weighing.
+// Caller must hold p.mu.
+func (p *Personality) thinkTime(s positionSummary) time.Duration {
+	if s.forced {
+		return p.cfg.ThinkMin
+	}
+	mean := float64(p.cfg.ThinkMean) * (0.6 + 0.8*s.options)
+	think := time.Duration(mean * math.Exp(thinkSpread*p.rng.NormFloat64()-thinkSpread*thinkSpread/2))
+	think = max(think, p.cfg.ThinkMin)
+	if p.cfg.ThinkMax > 0 {
+		think = min(think, p.cfg.ThinkMax)
+	}
+	return think
+}
+
+// Emote returns a line for event, or "" when the bot keeps quiet
+func (p *Personality) Emote(event string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.emote(event)
+}
+
+// emote is Emote for callers holding p.mu
+func (p *Personality) emote(event string) string {
+	lines := p.cfg.Emotes[event]
+	if len(lines) == 0 || p.cfg.EmoteChance > 0 && p.rng.Float64() >= p.cfg.EmoteChance {
+		return ""
+	}
+	return lines[p.rng.IntN(len(lines))]
+}
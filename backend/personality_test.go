@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+// fakeClock moves only when slept on or advanced by hand
+type fakeClock struct {
+	now   time.Time
+	slept []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.slept = append(c.slept, d)
+	c.now = c.now.Add(d)
+}
+
+// fixedEngine always plays col, taking took on clock to decide
+type fixedEngine struct {
+	col   int
+	took  time.Duration
+	clock *fakeClock
+}
+
+func (e fixedEngine) Name() string { return "fixed" }
+
+func (e fixedEngine) ChooseMove(Position, time.Duration) int {
+	e.clock.now = e.clock.now.Add(e.took)
+	return e.col
+}
+
+func mustParse(t *testing.T, s string) Position {
+	t.Helper()
+	pos, err := ParsePosition(s)
+	if err != nil {
+		t.Fatalf("ParsePosition(%q): %v", s, err)
+	}
+	return pos
+}
+
+func newTestPersonality(cfg PersonalityConfig, clock *fakeClock, seed uint64) *Personality {
+	return NewPersonality(cfg, 350*time.Millisecond, clock, rand.New(rand.NewPCG(seed, 0)))
+}
+
+func TestThinkTimeBounds(t *testing.T) {
+	cfg := PersonalityConfig{ThinkMin: 300 * time.Millisecond, ThinkMean: time.Second, ThinkMax: 1500 * time.Millisecond}
+	clock := &fakeClock{}
+	p := newTestPersonality(cfg, clock, 1)
+	start := mustParse(t, StartPosition)
+	engine := fixedEngine{col: 3, clock: clock}
+
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 500; i++ {
+		p.Move(engine, start, time.Second)
+	}
+	if len(clock.slept) != 500 {
+		t.Fatalf("slept %d times, want 500", len(clock.slept))
+	}
+	for _, d := range clock.slept {
+		if d < cfg.ThinkMin || d > cfg.ThinkMax {
+			t.Fatalf("think time %s outside [%s, %s]", d, cfg.ThinkMin, cfg.ThinkMax)
+		}
+		seen[d] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("only %d distinct think times in 500 moves", len(seen))
+	}
+}
+
+func TestThinkTimeCountsEngineTime(t *testing.T) {
+	cfg := PersonalityConfig{ThinkMin: time.Second, ThinkMean: time.Second, ThinkMax: time.Second}
+	clock := &fakeClock{}
+	p := newTestPersonality(cfg, clock, 1)
+	start := mustParse(t, StartPosition)
+
+	p.Move(fixedEngine{col: 3, took: 400 * time.Millisecond, clock: clock}, start, time.Second)
+	p.Move(fixedEngine{col: 3, took: 2 * time.Second, clock: clock}, start, time.Second)
+	if len(clock.slept) != 1 || clock.slept[0] != 600*time.Millisecond {
+		t.Errorf("slept %v, want [600ms] and no sleep once the engine ran over", clock.slept)
+	}
+}
+
+func TestThinkTimeForcedMove(t *testing.T) {
+	cfg := PersonalityConfig{ThinkMin: 200 * time.Millisecond, ThinkMean: 2 * time.Second, ThinkMax: 5 * time.Second, ErrorRate: 1}
+	clock := &fakeClock{}
+	p := newTestPersonality(cfg, clock, 1)
+	// x threatens the bottom row at column 4; o must block
+	pos := mustParse(t, "7/7/7/7/o6/oxxx3 o classic")
+
+	for i := 0; i < 50; i++ {
+		col, _ := p.Move(fixedEngine{col: 4, clock: clock}, pos, time.Second)
+		if col != 4 {
+			t.Fatalf("forced move changed to column %d", col)
+		}
+	}
+	for _, d := range clock.slept {
+		if d != cfg.ThinkMin {
+			t.Fatalf("forced move thought for %s, want %s", d, cfg.ThinkMin)
+		}
+	}
+}
+
+func TestThinkTimeDefaultsToDelay(t *testing.T) {
+	clock := &fakeClock{}
+	p := newTestPersonality(PersonalityConfig{}, clock, 1)
+	p.Move(fixedEngine{col: 3, clock: clock}, mustParse(t, StartPosition), time.Second)
+	if len(clock.slept) != 1 || clock.slept[0] != 350*time.Millisecond {
+		t.Errorf("slept %v, want [350ms]", clock.slept)
+	}
+}
+
+func TestMistakeRate(t *testing.T) {
+	start := mustParse(t, StartPosition)
+	// o is to move and only column 4 stops x's four; every other move loses
+	safeOnly := mustParse(t, "7/7/7/7/o6/oxxx3 o classic")
+	tests := []struct {
+		rate     float64
+		min, max int
+	}{
+		{0, 0, 0},
+		{0.2, 150, 250},
+		{1, 1000, 1000},
+	}
+	for _, tt := range tests {
+		clock := &fakeClock{}
+		p := newTestPersonality(PersonalityConfig{ErrorRate: tt.rate}, clock, 3)
+		engine := fixedEngine{col: 3, clock: clock}
+		mistakes := 0
+		for i := 0; i < 1000; i++ {
+			col, _ := p.Move(engine, start, time.Second)
+			if col != 3 {
+				mistakes++
+			}
+		}
+		if mistakes < tt.min || mistakes > tt.max {
+			t.Errorf("error rate %v: %d mistakes in 1000 moves, want %d to %d", tt.rate, mistakes, tt.min, tt.max)
+		}
+
+		for i := 0; i < 100; i++ {
+			if col, _ := p.Move(fixedEngine{col: 4, clock: clock}, safeOnly, time.Second); col != 4 {
+				t.Fatalf("error rate %v: played losing column %d", tt.rate, col)
+			}
+		}
+	}
+}
+
+func TestPersonalitySeedRepeats(t *testing.T) {
+	cfg := defaultPersonalities()["minimax-easy"]
+	start := mustParse(t, StartPosition)
+	run := func() ([]int, []time.Duration) {
+		clock := &fakeClock{}
+		p := newTestPersonality(cfg, clock, 9)
+		var cols []int
+		for i := 0; i < 50; i++ {
+			col, _ := p.Move(fixedEngine{col: 3, clock: clock}, start, time.Second)
+			cols = append(cols, col)
+		}
+		return cols, clock.slept
+	}
+	cols1, slept1 := run()
+	cols2, slept2 := run()
+	for i := range cols1 {
+		if cols1[i] != cols2[i] || slept1[i] != slept2[i] {
+			t.Fatalf("move %d: %d after %s, then %d after %s", i, cols1[i], slept1[i], cols2[i], slept2[i])
+		}
+	}
+}
+
+func TestEmotes(t *testing.T) {
+	cfg := PersonalityConfig{Emotes: map[string][]string{EmoteWin: {"gg"}, EmoteAhead: {"uh oh"}}}
+	p := newTestPersonality(cfg, &fakeClock{}, 1)
+	if got := p.Emote(EmoteWin); got != "gg" {
+		t.Errorf("Emote(win) = %q, want gg", got)
+	}
+	if got := p.Emote(EmoteLoss); got != "" {
+		t.Errorf("Emote(loss) = %q, want nothing", got)
+	}
+
+	// o wins at once in column 6; the ahead emote comes only the first time
+	clock := &fakeClock{}
+	p = newTestPersonality(cfg, clock, 1)
+	pos := mustParse(t, "7/7/7/6o/x5o/xxx3o o classic")
+	if _, emote := p.Move(fixedEngine{col: 6, clock: clock}, pos, time.Second); emote != "uh oh" {
+		t.Errorf("first winning move emoted %q, want uh oh", emote)
+	}
+	if _, emote := p.Move(fixedEngine{col: 6, clock: clock}, pos, time.Second); emote != "" {
+		t.Errorf("second winning move emoted %q, want nothing", emote)
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultRating = 1200.0
+	eloK          = 32.0
+)
+
+// eloUpdate returns both new ratings after a game where player 1 scored
+// score1 (1 for a win, 0.5 for a draw, 0 for a loss)
+func eloUpdate(r1, r2, score1 float64) (float64, float64) {
+	expected1 := 1 / (1 + math.Pow(10, (r2-r1)/400))
+	delta := eloK * (score1 - expected1)
+	return r1 + delta, r2 - delta
+}
+
+// orZero reads a numeric field in an update pipeline, treating a missing
+// field as zero
+func orZero(field string) bson.M {
+	return bson.M{"$ifNull": bson.A{"$" + field, 0}}
+}
+
+func plus(field string, n int) bson.M {
+	return bson.M{"$add": bson.A{orZero(field), n}}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// RecordPlayerStats folds a finished game into both players' player_stats
+// documents so profiles never have to scan game_results. Ratings only move
+// in rated games, and games played with hints are left out altogether.
+func (db *MongoDB) RecordPlayerStats(ctx context.Context, res GameResult) error {
+	if res.Hints > 0 {
+		return nil
+	}
+	coll := db.Database.Collection("player_stats")
+
+	score1 := 0.5
+	switch res.Outcome {
+	case ResultP1Win:
+		score1 = 1
+	case ResultP2Win:
+		score1 = 0
+	}
+
+	sides := []struct {
+		id, name, seat string
+		won            bool
+	}{
+		{res.Player1ID, res.Player1, "p1", res.Outcome == ResultP1Win},
+		{res.Player2ID, res.Player2, "p2", res.Outcome == ResultP2Win},
+	}
+	for _, s := range sides {
+		draw := res.Outcome == ResultDraw
+		lost := !draw && !s.won
+		winMoves := 0
+		if s.won {
+			winMoves = res.Moves
+		}
+		streak := interface{}(0)
+		if s.won {
+			streak = plus("current_streak", 1)
+		}
+		update := mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"username":           s.name,
+				"games":              plus("games", 1),
+				"wins":               plus("wins", boolToInt(s.won)),
+				"losses":             plus("losses", boolToInt(lost)),
+				"draws":              plus("draws", boolToInt(draw)),
+				"forfeits":           plus("forfeits", boolToInt(lost && res.Forfeit)),
+				"games_as_" + s.seat: plus("games_as_"+s.seat, 1),
+				"wins_as_" + s.seat:  plus("wins_as_"+s.seat, boolToInt(s.won)),
+				"win_moves":          plus("win_moves", winMoves),
+				"current_streak":     streak,
+				"rating":             bson.M{"$ifNull": bson.A{"$rating", DefaultRating}},
+				"rating_version":     orZero("rating_version"),
+				"rated_games":        plus("rated_games", boolToInt(res.Rated)),
+				"last_played_at":     res.CreatedAt,
+			}}},
+			{{Key: "$set", Value: bson.M{
+				"best_streak": bson.M{"$max": bson.A{orZero("best_streak"), "$current_streak"}},
+			}}},
+		}
+		_, err := coll.UpdateOne(ctx, bson.M{"_id": s.id}, update, options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	if res.Rated {
+		return recordRatings(ctx, coll, res.Player1ID, res.Player2ID, score1)
+	}
+	return nil
+}
+
+// ratingRetries bounds how often recordRatings rereads a rating that
+// another game changed under it
+const ratingRetries = 5
+
+// recordRatings applies the Elo update for one game to both players. Each
+// write only lands if the player's rating_version is the one read, so two
+// games finishing at once cannot overwrite each other's change. Player 1
+// is written first; if player 2 changed in the meantime only their side is
+// recomputed, against the rating player 1 had, so no game counts twice.
+// Both documents must already exist.
+func recordRatings(ctx context.Context, coll *mongo.Collection, id1, id2 string, score1 float64) error {
+	readRating := func(id string) (PlayerStats, error) {
+		var d PlayerStats
+		err := coll.FindOne(ctx, bson.M{"_id": id}, options.FindOne().SetProjection(bson.M{"rating": 1, "rating_version": 1})).Decode(&d)
+		return d, err
+	}
+	writeRating := func(id string, d PlayerStats, rating float64) (bool, error) {
+		res, err := coll.UpdateOne(ctx, bson.M{"_id": id, "rating_version": d.RatingVersion},
+			bson.M{"$set": bson.M{"rating": rating, "rating_version": d.RatingVersion + 1}})
+		if err != nil {
+			return false, err
+		}
+		return res.MatchedCount == 1, nil
+	}
+
+	var p1, p2 PlayerStats
+	var new1, new2 float64
+	for i := 0; ; i++ {
+		if i == ratingRetries {
+			return fmt.Errorf("rating of %s kept changing", id1)
+		}
+		var err error
+		if p1, err = readRating(id1); err != nil {
+			return err
+		}
+		if p2, err = readRating(id2); err != nil {
+			return err
+		}
+		new1, new2 = eloUpdate(p1.Rating, p2.Rating, score1)
+		ok, err := writeRating(id1, p1, new1)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+	}
+	for i := 0; ; i++ {
+		ok, err := writeRating(id2, p2, new2)
+		if err != nil || ok {
+			return err
+		}
+		if i == ratingRetries {
+			return fmt.Errorf("rating of %s kept changing", id2)
+		}
+		if p2, err = readRating(id2); err != nil {
+			return err
+		}
+		_, new2 = eloUpdate(p1.Rating, p2.Rating, score1)
+	}
+}
+
+// playerByName returns the stats document of the player a name refers to.
+// Accounts are found the way logins are, so any spelling that folds to the
+// same skeleton works; guests and server bots are keyed by their name.
+func (db *MongoDB) playerByName(ctx context.Context, username string) (PlayerStats, error) {
+	id := username
+	if !isGuestName(username) {
+		u, err := findUserByName(ctx, db.Database.Collection("users"), username)
+		switch {
+		case err == nil:
+			id = u.ID.Hex()
+		case err != mongo.ErrNoDocuments:
+			return PlayerStats{}, err
+		}
+	}
+	var st PlayerStats
+	err := db.Database.Collection("player_stats").FindOne(ctx, bson.M{"_id": id}).Decode(&st)
+	return st, err
+}
+
+// recentGames returns the player's last limit results, newest first
+func (db *MongoDB) recentGames(ctx context.Context, playerID string, limit int64) ([]GameResult, error) {
+	coll := db.Database.Collection("game_results")
+	cursor, err := coll.Find(ctx,
+		bson.M{"$or": bson.A{bson.M{"player1_id": playerID}, bson.M{"player2_id": playerID}}},
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var games []GameResult
+	err = cursor.All(ctx, &games)
+	return games, err
+}
+
+type SeatRecord struct {
+	Games   int     `json:"games"`
+	Wins    int     `json:"wins"`
+	WinRate float64 `json:"win_rate"`
+}
+
+type GameSummary struct {
+	GameID    string    `json:"game_id"`
+	Opponent  string    `json:"opponent"`
+	Seat      int       `json:"seat"`
+	Outcome   string    `json:"outcome"` // win, loss or draw from the player's side
+	Forfeit   bool      `json:"forfeit,omitempty"`
+	Moves     int       `json:"moves"`
+	Rated     bool      `json:"rated"`
+	PlayedAt  time.Time `json:"played_at"`
+	ReplayURL string    `json:"replay_url"`
+}
+
+type PlayerProfile struct {
+	PlayerID       string        `json:"player_id"`
+	Username       string        `json:"username"`
+	Games          int           `json:"games"`
+	Wins           int           `json:"wins"`
+	Losses         int           `json:"losses"`
+	Draws          int           `json:"draws"`
+	Forfeits       int           `json:"forfeits"`
+	WinRate        float64       `json:"win_rate"`
+	AsPlayer1      SeatRecord    `json:"as_player1"`
+	AsPlayer2      SeatRecord    `json:"as_player2"`
+	AvgMovesPerWin float64       `json:"avg_moves_per_win"`
+	CurrentStreak  int           `json:"current_streak"`
+	BestStreak     int           `json:"best_streak"`
+	Rating         int           `json:"rating"`
+	RatedGames     int           `json:"rated_games"`
+	LastPlayedAt   time.Time     `json:"last_played_at"`
+	RecentGames    []GameSummary `json:"recent_games"`
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return math.Round(float64(a)/float64(b)*1000) / 1000
+}
+
+// summarize describes a game result from one player's side
+func summarize(res GameResult, playerID string) GameSummary {
+	seat, opponent := 1, res.Player2
+	if res.Player2ID == playerID {
+		seat, opponent = 2, res.Player1
+	}
+	outcome := "loss"
+	switch {
+	case res.Outcome == ResultDraw:
+		outcome = "draw"
+	case res.WinnerID == playerID:
+		outcome = "win"
+	}
+	return GameSummary{
+		GameID:    res.GameID,
+		Opponent:  opponent,
+		Seat:      seat,
+		Outcome:   outcome,
+		Forfeit:   res.Forfeit,
+		Moves:     res.Moves,
+		Rated:     res.Rated,
+		PlayedAt:  res.CreatedAt,
+		ReplayURL: replayURL(res.GameID),
+	}
+}
+
+// queryLimit reads a positive integer query parameter, clamped to max
+func queryLimit(r *http.Request, key string, def, max int) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(key))
+	if err != nil || n < 1 {
+		return def
+	}
+	return min(n, max)
+}
+
+// HeadToHead is the record between two players, from A's point of view
+type HeadToHead struct {
+	PlayerA         string        `json:"player_a"`
+	PlayerB         string        `json:"player_b"`
+	Games           int           `json:"games"`
+	WinsA           int           `json:"wins_a"`
+	WinsB           int           `json:"wins_b"`
+	Draws           int           `json:"draws"`
+	AvgMoves        float64       `json:"avg_moves"`
+	AvgDurationSecs float64       `json:"avg_duration_seconds"`
+	RecentGames     []GameSummary `json:"recent_games"`
+}
+
+// headToHead aggregates every game between two players, whichever side
+// each of them sat on
+func (db *MongoDB) headToHead(ctx context.Context, a, b string, limit int) (HeadToHead, []GameResult, error) {
+	var h HeadToHead
+	winsOf := func(id string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$winner_id", id}}, 1, 0}}}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"player1_id": a, "player2_id": b},
+			bson.M{"player1_id": b, "player2_id": a},
+		}}}},
+		{{Key: "$facet", Value: bson.M{
+			"totals": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":          nil,
+					"games":        bson.M{"$sum": 1},
+					"wins_a":       winsOf(a),
+					"wins_b":       winsOf(b),
+					"draws":        bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$outcome", ResultDraw}}, 1, 0}}},
+					"avg_moves":    bson.M{"$avg": "$moves"},
+					"avg_duration": bson.M{"$avg": "$duration"},
+				}},
+			},
+			"recent": bson.A{
+				bson.M{"$sort": bson.M{"created_at": -1}},
+				bson.M{"$limit": limit},
+			},
+		}}},
+	}
+	cursor, err := db.Database.Collection("game_results").Aggregate(ctx, pipeline)
+	if err != nil {
+		return h, nil, err
+	}
+	var out []struct {
+		Totals []struct {
+			Games       int     `bson:"games"`
+			WinsA       int     `bson:"wins_a"`
+			WinsB       int     `bson:"wins_b"`
+			Draws       int     `bson:"draws"`
+			AvgMoves    float64 `bson:"avg_moves"`
+			AvgDuration float64 `bson:"avg_duration"` // nanoseconds
+		} `bson:"totals"`
+		Recent []GameResult `bson:"recent"`
+	}
+	if err := cursor.All(ctx, &out); err != nil {
+		return h, nil, err
+	}
+	if len(out) == 0 {
+		return h, nil, nil
+	}
+	if t := out[0].Totals; len(t) > 0 {
+		h.Games, h.WinsA, h.WinsB, h.Draws = t[0].Games, t[0].WinsA, t[0].WinsB, t[0].Draws
+		h.AvgMoves = math.Round(t[0].AvgMoves*10) / 10
+		h.AvgDurationSecs = math.Round(time.Duration(t[0].AvgDuration).Seconds()*10) / 10
+	}
+	return h, out[0].Recent, nil
+}
+
+// registerPlayerRoutes mounts the player profile and head-to-head endpoints
+func registerPlayerRoutes(mux *http.ServeMux, db *MongoDB) {
+	mux.HandleFunc("GET /players/{username}", withRequestLog("player_profile", func(w http.ResponseWriter, r *http.Request) {
+		reqLog := requestLogger(r, "player_profile")
+		username := r.PathValue("username")
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		st, err := db.playerByName(ctx, username)
+		if err == mongo.ErrNoDocuments {
+			writeError(w, http.StatusNotFound, "player not found")
+			return
+		}
+		if err != nil {
+			reqLog.Error("player stats lookup failed", "username", username, "error", err)
+			writeError(w, http.StatusInternalServerError, "lookup failed")
+			return
+		}
+
+		limit := queryLimit(r, "limit", 10, 50)
+		recent, err := db.recentGames(ctx, st.PlayerID, int64(limit))
+		if err != nil {
+			reqLog.Error("recent games lookup failed", "username", username, "error", err)
+		}
+
+		p := PlayerProfile{
+			PlayerID:       st.PlayerID,
+			Username:       st.Username,
+			Games:          st.Games,
+			Wins:           st.Wins,
+			Losses:         st.Losses,
+			Draws:          st.Draws,
+			Forfeits:       st.Forfeits,
+			WinRate:        ratio(st.Wins, st.Games),
+			AsPlayer1:      SeatRecord{Games: st.GamesAsP1, Wins: st.WinsAsP1, WinRate: ratio(st.WinsAsP1, st.GamesAsP1)},
+			AsPlayer2:      SeatRecord{Games: st.GamesAsP2, Wins: st.WinsAsP2, WinRate: ratio(st.WinsAsP2, st.GamesAsP2)},
+			AvgMovesPerWin: ratio(st.WinMoves, st.Wins),
+			CurrentStreak:  st.CurrentStreak,
+			BestStreak:     st.BestStreak,
+			Rating:         int(math.Round(st.Rating)),
+			RatedGames:     st.RatedGames,
+			LastPlayedAt:   st.LastPlayedAt,
+			RecentGames:    []GameSummary{},
+		}
+		for _, res := range recent {
+			p.RecentGames = append(p.RecentGames, summarize(res, st.PlayerID))
+		}
+		writeJSON(w, p)
+	}))
+
+	mux.HandleFunc("GET /players/{a}/vs/{b}", withRequestLog("head_to_head", func(w http.ResponseWriter, r *http.Request) {
+		reqLog := requestLogger(r, "head_to_head")
+		nameA, nameB := r.PathValue("a"), r.PathValue("b")
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		var players [2]PlayerStats
+		for i, name := range []string{nameA, nameB} {
+			st, err := db.playerByName(ctx, name)
+			if err == mongo.ErrNoDocuments {
+				writeError(w, http.StatusNotFound, "player not found: "+name)
+				return
+			}
+			if err != nil {
+				reqLog.Error("player stats lookup failed", "username", name, "error", err)
+				writeError(w, http.StatusInternalServerError, "lookup failed")
+				return
+			}
+			players[i] = st
+		}
+		a, b := players[0], players[1]
+		if a.PlayerID == b.PlayerID {
+			writeError(w, http.StatusBadRequest, "a player has no record against themselves")
+			return
+		}
+
+		h, recent, err := db.headToHead(ctx, a.PlayerID, b.PlayerID, queryLimit(r, "limit", 10, 50))
+		if err != nil {
+			reqLog.Error("head to head query failed", "a", nameA, "b", nameB, "error", err)
+			writeError(w, http.StatusInternalServerError, "lookup failed")
+			return
+		}
+		h.PlayerA, h.PlayerB = a.Username, b.Username
+		h.RecentGames = []GameSummary{}
+		for _, res := range recent {
+			h.RecentGames = append(h.RecentGames, summarize(res, a.PlayerID))
+		}
+		writeJSON(w, h)
+	}))
+}
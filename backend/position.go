@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// A position is written like a FEN record: the rows from top to bottom
+// separated by '/', each row listing discs left to right with 'x' for
+// player 1, 'o' for player 2 and a digit for a run of empty cells, then the
+// side to move and the variant:
+//
+//	7/7/7/7/3o3/2xx3 o classic
+//
+// Player 1 always moves first, so the side to move follows from the disc
+// counts; it is still written out so a position reads on its own.
+type Position struct {
+	Board    [Rows][Cols]Player
+	Turn     Player
+	Variant  string
+	Finished bool
+	Result   Result
+}
+
+var discChars = map[Player]byte{P1: 'x', P2: 'o'}
+
+// StartPosition is the empty board with player 1 to move
+const StartPosition = "7/7/7/7/7/7 x classic"
+
+// String encodes the position
+func (p Position) String() string {
+	var b strings.Builder
+	for r := 0; r < Rows; r++ {
+		if r > 0 {
+			b.WriteByte('/')
+		}
+		empty := 0
+		for c := 0; c < Cols; c++ {
+			if p.Board[r][c] == Empty {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				b.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+			b.WriteByte(discChars[p.Board[r][c]])
+		}
+		if empty > 0 {
+			b.WriteByte(byte('0' + empty))
+		}
+	}
+	b.WriteByte(' ')
+	b.WriteByte(discChars[p.Turn])
+	b.WriteByte(' ')
+	b.WriteString(p.Variant)
+	return b.String()
+}
+
+// ParsePosition decodes a position and checks that it can arise in a real
+// game: discs rest on the bottom or on other discs, the players have taken
+// turns, and nobody kept playing after four in a row. A position where the
+// last move won, or the board filled up, comes back finished.
+func ParsePosition(s string) (Position, error) {
+	var p Position
+	fields := strings.Fields(s)
+	if len(fields) != 3 {
+		return p, errors.New("position needs the board, the side to move and the variant")
+	}
+	p.Variant = fields[2]
+	if p.Variant != VariantClassic {
+		return p, fmt.Errorf("unsupported variant %q", p.Variant)
+	}
+
+	rows := strings.Split(fields[0], "/")
+	if len(rows) != Rows {
+		return p, fmt.Errorf("board has %d rows, want %d", len(rows), Rows)
+	}
+	counts := map[Player]int{}
+	for r, row := range rows {
+		c := 0
+		for i := 0; i < len(row); i++ {
+			ch := row[i]
+			switch {
+			case ch >= '1' && ch <= '0'+Cols:
+				c += int(ch - '0')
+			case ch == 'x' || ch == 'o':
+				if c < Cols {
+					mark := P1
+					if ch == 'o' {
+						mark = P2
+					}
+					p.Board[r][c] = mark
+					counts[mark]++
+				}
+				c++
+			default:
+				return p, fmt.Errorf("row %d: unexpected %q", r+1, ch)
+			}
+		}
+		if c != Cols {
+			return p, fmt.Errorf("row %d covers %d columns, want %d", r+1, c, Cols)
+		}
+	}
+
+	for c := 0; c < Cols; c++ {
+		for r := 0; r < Rows-1; r++ {
+			if p.Board[r][c] != Empty && p.Board[r+1][c] == Empty {
+				return p, fmt.Errorf("column %d has a floating disc", c+1)
+			}
+		}
+	}
+
+	switch fields[1] {
+	case "x":
+		p.Turn = P1
+	case "o":
+		p.Turn = P2
+	default:
+		return p, fmt.Errorf("side to move must be x or o, not %q", fields[1])
+	}
+	switch counts[P1] - counts[P2] {
+	case 0:
+		if p.Turn != P1 {
+			return p, errors.New("equal disc counts mean x is to move")
+		}
+	case 1:
+		if p.Turn != P2 {
+			return p, errors.New("x has one disc more, so o is to move")
+		}
+	default:
+		return p, fmt.Errorf("x has %d discs and o has %d; players alternate with x first", counts[P1], counts[P2])
+	}
+
+	last := P1
+	if p.Turn == P1 {
+		last = P2
+	}
+	if hasFour(&p.Board, p.Turn) {
+		return p, errors.New("the side to move already has four in a row")
+	}
+	if hasFour(&p.Board, last) {
+		if !lastMoveWon(&p.Board, last) {
+			return p, errors.New("play continued after four in a row")
+		}
+		p.Finished, p.Result = true, winFor(last)
+	} else if counts[P1]+counts[P2] == Rows*Cols {
+		p.Finished, p.Result = true, ResultDraw
+	}
+	return p, nil
+}
+
+// hasFour reports whether mark has four in a row anywhere on the board
+func hasFour(board *[Rows][Cols]Player, mark Player) bool {
+	for r := 0; r < Rows; r++ {
+		for c := 0; c < Cols; c++ {
+			if board[r][c] == mark && fourAt(board, r, c, mark) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lastMoveWon reports whether removing one of mark's discs from the top of
+// a column removes every four in a row, i.e. whether the fours could all
+// have been completed by mark's last move
+func lastMoveWon(board *[Rows][Cols]Player, mark Player) bool {
+	for c := 0; c < Cols; c++ {
+		for r := 0; r < Rows; r++ {
+			if board[r][c] == Empty {
+				continue
+			}
+			if board[r][c] == mark {
+				before := *board
+				before[r][c] = Empty
+				if !hasFour(&before, mark) {
+					return true
+				}
+			}
+			break
+		}
+	}
+	return false
+}
+
+// Position returns the current position of the game
+func (g *GameLogic) Position() Position {
+	p := Position{Board: g.Board, Variant: VariantClassic, Finished: g.Finished, Result: g.Result}
+	// Turn is not toggled after the last move of a game, so derive the
+	// side to move from the discs as ParsePosition does
+	discs := 0
+	for r := 0; r < Rows; r++ {
+		for c := 0; c < Cols; c++ {
+			if g.Board[r][c] != Empty {
+				discs++
+			}
+		}
+	}
+	p.Turn = P1
+	if discs%2 == 1 {
+		p.Turn = P2
+	}
+	return p
+}
+
+// NewGameFrom starts a game from a set position. Moves counts the discs
+// already on the board.
+func NewGameFrom(id, p1, p2 string, pos Position) *GameLogic {
+	g := NewGame(id, p1, p2)
+	g.Board, g.Turn = pos.Board, pos.Turn
+	g.Finished, g.Result = pos.Finished, pos.Result
+	g.Start = pos.String()
+	for r := 0; r < Rows; r++ {
+		for c := 0; c < Cols; c++ {
+			if g.Board[r][c] != Empty {
+				g.Moves++
+			}
+		}
+	}
+	return g
+}
+
+// LegalColumns returns the columns that still have room, or none once the
+// game is over
+func (g *GameLogic) LegalColumns() []int {
+	cols := []int{}
+	if g.Finished {
+		return cols
+	}
+	for c := 0; c < Cols; c++ {
+		if g.Board[0][c] == Empty {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// PositionInfo describes a valid position for clients
+type PositionInfo struct {
+	Position     string             `json:"position"` // canonical form
+	Board        [Rows][Cols]Player `json:"board"`
+	Turn         string             `json:"turn"` // "x" or "o"
+	Discs        int                `json:"discs"`
+	Finished     bool               `json:"finished"`
+	Outcome      Result             `json:"outcome,omitempty"`
+	LegalColumns []int              `json:"legal_columns"` // 0-based
+}
+
+// registerPositionRoutes mounts GET /positions/validate?position=...
+func registerPositionRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /positions/validate", withRequestLog("validate_position", func(w http.ResponseWriter, r *http.Request) {
+		pos, err := ParsePosition(r.URL.Query().Get("position"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid position: "+err.Error())
+			return
+		}
+		g := NewGameFrom("", "", "", pos)
+		writeJSON(w, PositionInfo{
+			Position:     pos.String(),
+			Board:        pos.Board,
+			Turn:         string(discChars[pos.Turn]),
+			Discs:        g.Moves,
+			Finished:     pos.Finished,
+			Outcome:      pos.Result,
+			LegalColumns: g.LegalColumns(),
+		})
+	}))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePositionRoundTrip(t *testing.T) {
+	tests := []struct {
+		pos      string
+		finished bool
+		result   Result
+	}{
+		{StartPosition, false, ResultNone},
+		{"7/7/7/7/3o3/2xx3 o classic", false, ResultNone},
+		{"7/7/7/7/ooo4/xxxx3 o classic", true, ResultP1Win},
+		{"7/7/7/o6/oxx4/oxx4 o classic", false, ResultNone},
+		{"oxxxooo/oooxoxx/oxxooxx/xoxoxox/oxooxoo/xxoxoxx x classic", true, ResultDraw},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pos, func(t *testing.T) {
+			p, err := ParsePosition(tt.pos)
+			if err != nil {
+				t.Fatalf("ParsePosition: %v", err)
+			}
+			if got := p.String(); got != tt.pos {
+				t.Errorf("String() = %q, want %q", got, tt.pos)
+			}
+			if p.Finished != tt.finished || p.Result != tt.result {
+				t.Errorf("finished, result = %v, %q, want %v, %q", p.Finished, p.Result, tt.finished, tt.result)
+			}
+		})
+	}
+}
+
+func TestParsePositionErrors(t *testing.T) {
+	tests := []struct {
+		name, pos, want string
+	}{
+		{"missing fields", "7/7/7/7/7/7 x", "needs the board"},
+		{"unknown variant", "7/7/7/7/7/7 x popout", "unsupported variant"},
+		{"too few rows", "7/7/7/7/7 x classic", "5 rows"},
+		{"short row", "7/7/7/7/7/6 x classic", "covers 6 columns"},
+		{"long row", "7/7/7/7/7/xo6 o classic", "covers 8 columns"},
+		{"bad character", "7/7/7/7/7/3z3 x classic", "unexpected"},
+		{"floating disc", "7/7/7/7/x6/1o5 x classic", "floating"},
+		{"bad side", "7/7/7/7/7/7 y classic", "side to move"},
+		{"wrong side", "7/7/7/7/7/x6 x classic", "o is to move"},
+		{"wrong side on equal counts", "7/7/7/7/7/xo5 o classic", "x is to move"},
+		{"too many discs", "7/7/7/7/7/xx5 o classic", "players alternate"},
+		{"mover already won", "7/7/7/7/oooo3/xxxx3 x classic", "already has four"},
+		{"play after a win", "7/7/7/x6/xooo3/xxxxoo1 o classic", "after four in a row"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePosition(tt.pos)
+			if err == nil {
+				t.Fatalf("ParsePosition(%q) succeeded", tt.pos)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %q does not mention %q", err, tt.want)
+			}
+		})
+	}
+}
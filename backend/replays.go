@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// replayURL is where a finished game's moves can be fetched
+func replayURL(gameID string) string {
+	return "/games/" + gameID
+}
+
+// GameReplay is a game's move list, enough for a client to step through it
+type GameReplay struct {
+	GameID    string    `json:"game_id"`
+	Player1   string    `json:"player1"`
+	Player2   string    `json:"player2"`
+	Winner    string    `json:"winner"`
+	Outcome   Result    `json:"outcome"`
+	Finished  bool      `json:"finished"`
+	Columns   []int     `json:"columns"` // 0-based, in the order they were played
+	Start     string    `json:"start_position,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// registerReplayRoutes mounts GET /games/{id}
+func registerReplayRoutes(mux *http.ServeMux, db *MongoDB) {
+	mux.HandleFunc("GET /games/{id}", withRequestLog("replay", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		var g GameDB
+		err := db.Database.Collection("games").FindOne(ctx, bson.M{"game_id": id}).Decode(&g)
+		if err == mongo.ErrNoDocuments {
+			if archive, _ := db.archiveOf(ctx, id); archive != "" {
+				writeError(w, http.StatusGone, "game moved to archive "+archive)
+				return
+			}
+			writeError(w, http.StatusNotFound, "game not found")
+			return
+		}
+		if err != nil {
+			requestLogger(r, "replay").Error("game lookup failed", "game_id", id, "error", err)
+			writeError(w, http.StatusInternalServerError, "lookup failed")
+			return
+		}
+
+		columns := g.MoveLog
+		if columns == nil {
+			columns = []int{} // games finished before moves were recorded
+		}
+		writeJSON(w, GameReplay{
+			GameID:    g.GameID,
+			Player1:   g.Player1,
+			Player2:   g.Player2,
+			Winner:    g.Winner,
+			Outcome:   g.Outcome,
+			Finished:  g.Finished,
+			Columns:   columns,
+			Start:     g.Start,
+			StartedAt: g.StartedAt,
+		})
+	}))
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// archiveBatch caps how many games one run archives, which bounds the size
+// of an archive file and of the delete that follows it
+const archiveBatch = 10000
+
+// RetentionJob keeps the games collection from growing forever. It marks
+// unfinished games nobody came back to as aborted and moves finished games
+// past their retention into gzip JSONL archives. game_results, player_stats
+// and site_stats keep the summaries, so profiles and leaderboards are
+// unaffected.
+type RetentionJob struct {
+	cfg     RetentionConfig
+	db      *MongoDB
+	hub     *Hub // games live in this process are never aborted; may be nil
+	metrics Metrics
+	log     *slog.Logger
+}
+
+func NewRetentionJob(cfg RetentionConfig, db *MongoDB, hub *Hub, metrics Metrics) *RetentionJob {
+	if metrics == nil {
+		metrics = nopMetrics{}
+	}
+	return &RetentionJob{cfg: cfg, db: db, hub: hub, metrics: metrics, log: slog.With("op", "retention")}
+}
+
+// RetentionReport is what one run changed, or would change in dry-run mode
+type RetentionReport struct {
+	Aborted  int
+	Archived int
+	File     string
+}
+
+// Run calls RunOnce every interval until ctx is done
+func (j *RetentionJob) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := j.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			j.log.Error("retention run failed", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce applies every enabled policy once
+func (j *RetentionJob) RunOnce(ctx context.Context) (RetentionReport, error) {
+	var rep RetentionReport
+	start := time.Now()
+	result := "ok"
+	if j.cfg.DryRun {
+		result = "dry_run"
+	}
+
+	var err error
+	if j.cfg.AbortAfter > 0 {
+		rep.Aborted, err = j.abortAbandoned(ctx, start.Add(-j.cfg.AbortAfter))
+	}
+	if err == nil && j.cfg.ArchiveAfter > 0 {
+		rep.Archived, rep.File, err = j.archiveFinished(ctx, start.Add(-j.cfg.ArchiveAfter))
+	}
+	if err != nil {
+		result = "error"
+	}
+	j.metrics.RetentionRun(result, rep.Aborted, rep.Archived, time.Since(start))
+	j.log.Info("retention run finished", "dry_run", j.cfg.DryRun, "aborted", rep.Aborted,
+		"archived", rep.Archived, "file", rep.File, "took", time.Since(start).String())
+	return rep, err
+}
+
+// abortAbandoned marks unfinished games started and untouched since cutoff
+// as aborted. That includes suspended games whose players never rejoined.
+// Games are only written when they start, suspend or end, so created_at
+// keeps a game that starts during the run, or one stored without
+// updated_at, from being taken for abandoned.
+func (j *RetentionJob) abortAbandoned(ctx context.Context, cutoff time.Time) (int, error) {
+	filter := bson.M{
+		"finished":   false,
+		"aborted":    bson.M{"$ne": true},
+		"created_at": bson.M{"$lt": cutoff},
+		"updated_at": bson.M{"$lt": cutoff},
+	}
+	if j.hub != nil {
+		if live := j.hub.LiveGameIDs(); len(live) > 0 {
+			filter["game_id"] = bson.M{"$nin": live}
+		}
+	}
+	coll := j.db.Database.Collection("games")
+
+	if j.cfg.DryRun {
+		n, err := coll.CountDocuments(ctx, filter)
+		return int(n), err
+	}
+	res, err := coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{
+		"finished":   true,
+		"aborted":    true,
+		"suspended":  false,
+		"updated_at": time.Now(),
+	}})
+	if err != nil {
+		return 0, fmt.Errorf("aborting games: %w", err)
+	}
+	return int(res.ModifiedCount), nil
+}
+
+// archiveFinished writes finished games older than cutoff, with their
+// results, to a new archive file and then removes them from games. The file
+// is synced before anything is deleted, so a failure leaves the games in
+// place and at worst archives them twice.
+func (j *RetentionJob) archiveFinished(ctx context.Context, cutoff time.Time) (int, string, error) {
+	games := j.db.Database.Collection("games")
+	results := j.db.Database.Collection("game_results")
+	filter := bson.M{"finished": true, "updated_at": bson.M{"$lt": cutoff}}
+
+	if j.cfg.DryRun {
+		n, err := games.CountDocuments(ctx, filter)
+		return int(n), "", err
+	}
+
+	cursor, err := games.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}}).SetLimit(archiveBatch))
+	if err != nil {
+		return 0, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var (
+		w       *archiveWriter
+		ids     []primitive.ObjectID
+		gameIDs []string
+	)
+	for cursor.Next(ctx) {
+		// Lines keep the stored field names, with the result document
+		// embedded, so mongoimport can restore them
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			j.log.Warn("skipping undecodable game", "error", err)
+			continue
+		}
+		id, _ := doc["_id"].(primitive.ObjectID)
+		gameID, _ := doc["game_id"].(string)
+		var res bson.M
+		if err := results.FindOne(ctx, bson.M{"game_id": gameID}).Decode(&res); err == nil {
+			doc["result"] = res
+		}
+
+		if w == nil {
+			if w, err = newArchiveWriter(j.cfg.ArchiveDir, time.Now()); err != nil {
+				return 0, "", err
+			}
+			defer w.Abort()
+		}
+		if err := w.Write(doc); err != nil {
+			return 0, "", err
+		}
+		ids = append(ids, id)
+		gameIDs = append(gameIDs, gameID)
+	}
+	if err := cursor.Err(); err != nil {
+		return 0, "", err
+	}
+	if w == nil {
+		return 0, "", nil
+	}
+	if err := w.Close(); err != nil {
+		return 0, "", err
+	}
+
+	name := filepath.Base(w.path)
+	if _, err := results.UpdateMany(ctx, bson.M{"game_id": bson.M{"$in": gameIDs}},
+		bson.M{"$set": bson.M{"archive": name}}); err != nil {
+		return 0, w.path, fmt.Errorf("marking results archived: %w", err)
+	}
+	if _, err := games.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return 0, w.path, fmt.Errorf("deleting archived games: %w", err)
+	}
+	return len(ids), w.path, nil
+}
+
+// archiveWriter writes gzip compressed JSON lines to a temporary file that
+// is renamed into place on Close. The final name is reserved up front, so
+// two runs in the same second never write over each other's archive.
+type archiveWriter struct {
+	path string
+	f    *os.File
+	buf  *bufio.Writer
+	gz   *gzip.Writer
+	done bool
+}
+
+func newArchiveWriter(dir string, now time.Time) (*archiveWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path, err := reserveArchiveName(dir, "games-"+now.UTC().Format("20060102T150405Z"))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(dir, ".games-*.tmp")
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	buf := bufio.NewWriter(f)
+	gz := gzip.NewWriter(buf)
+	return &archiveWriter{path: path, f: f, buf: buf, gz: gz}, nil
+}
+
+// reserveArchiveName creates an empty file named base.jsonl.gz in dir, or
+// base-2.jsonl.gz and so on if that is taken, and returns its path
+func reserveArchiveName(dir, base string) (string, error) {
+	for n := 1; ; n++ {
+		name := base
+		if n > 1 {
+			name += fmt.Sprintf("-%d", n)
+		}
+		path := filepath.Join(dir, name+".jsonl.gz")
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
+
+// Write appends doc as one line of relaxed extended JSON
+func (w *archiveWriter) Write(doc bson.M) error {
+	line, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		return err
+	}
+	if _, err := w.gz.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close flushes and syncs the archive and moves it to its final name
+func (w *archiveWriter) Close() error {
+	if err := w.gz.Close(); err != nil {
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(w.f.Name(), w.path); err != nil {
+		return err
+	}
+	w.done = true
+	return nil
+}
+
+// Abort removes the temporary file and the reserved name unless Close
+// succeeded
+func (w *archiveWriter) Abort() {
+	if w.done {
+		return
+	}
+	w.f.Close()
+	os.Remove(w.f.Name())
+	os.Remove(w.path)
+}
+
+// archiveOf returns the archive file a finished game was moved to, if any
+func (db *MongoDB) archiveOf(ctx context.Context, gameID string) (string, error) {
+	var res struct {
+		Archive string `bson:"archive"`
+	}
+	err := db.Database.Collection("game_results").FindOne(ctx, bson.M{"game_id": gameID},
+		options.FindOne().SetProjection(bson.M{"archive": 1})).Decode(&res)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return res.Archive, err
+}
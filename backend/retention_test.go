@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestArchiveWritersInTheSameSecondKeepBothFiles(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var paths []string
+	for i := 0; i < 3; i++ {
+		w, err := newArchiveWriter(dir, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(bson.M{"game_id": i}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.Base(w.path))
+	}
+	want := []string{"games-20260301T120000Z.jsonl.gz", "games-20260301T120000Z-2.jsonl.gz", "games-20260301T120000Z-3.jsonl.gz"}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("archive %d named %s, want %s", i, paths[i], want[i])
+		}
+		if fi, err := os.Stat(filepath.Join(dir, want[i])); err != nil || fi.Size() == 0 {
+			t.Errorf("archive %s missing or empty: %v", want[i], err)
+		}
+	}
+
+	w, err := newArchiveWriter(dir, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Abort()
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(want) {
+		t.Errorf("aborted writer left files behind: %d entries, want %d", len(entries), len(want))
+	}
+}
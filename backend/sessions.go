@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Session policies decide what happens when a player connects while they
+// already have an open socket
+const (
+	SessionReject  = "reject"  // refuse the new connection
+	SessionReplace = "replace" // move the queue slot or game to the new socket
+	SessionMulti   = "multi"   // allow several sockets and games at once
+)
+
+var errAlreadyConnected = errors.New("you are already connected from another window")
+
+// claimSession registers a new client under its player ID according to the
+// session policy. It reports whether the client took over a queue slot or
+// game from an older socket, in which case it must not be queued again.
+// Guests are not authenticated, so they can never take over a session.
+// Caller must hold h.mu.
+func (h *Hub) claimSession(c *WSClient) (bool, error) {
+	existing := h.sessions[c.PlayerID]
+	policy := h.cfg.SessionPolicy
+	if policy == SessionReplace && isGuestName(c.Username) {
+		policy = SessionReject
+	}
+	// A bot account plays one game per socket, up to its limit
+	if c.Bot {
+		if len(existing) >= h.cfg.BotAccountGames {
+			return false, fmt.Errorf("bot accounts may play %d games at once", h.cfg.BotAccountGames)
+		}
+		policy = SessionMulti
+	}
+
+	tookOver := false
+	if len(existing) > 0 {
+		switch policy {
+		case SessionReject:
+			return false, errAlreadyConnected
+		case SessionReplace:
+			// releaseSession edits the list, so walk a copy
+			for _, old := range append([]*WSClient(nil), existing...) {
+				if h.takeOver(old, c) {
+					tookOver = true
+				}
+			}
+			existing = nil
+		}
+	}
+
+	h.sessions[c.PlayerID] = append(existing, c)
+	h.clients[c] = struct{}{}
+	return tookOver, nil
+}
+
+// releaseSession forgets a client. Caller must hold h.mu.
+func (h *Hub) releaseSession(c *WSClient) {
+	delete(h.clients, c)
+	list := h.sessions[c.PlayerID]
+	for i, s := range list {
+		if s == c {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(h.sessions, c.PlayerID)
+	} else {
+		h.sessions[c.PlayerID] = list
+	}
+}
+
+// releaseClient forgets a client that has gone and closes its Send
+// channel, which ends its writer. It may run more than once for a client;
+// Send is closed the first time. Caller must hold h.mu.
+func (h *Hub) releaseClient(c *WSClient) {
+	h.releaseSession(c)
+	if c.released {
+		return
+	}
+	c.released = true
+	close(c.Send)
+}
+
+// takeOver hands whatever old was doing to c and closes old. It reports
+// whether old was queued or in a game. Caller must hold h.mu.
+func (h *Hub) takeOver(old, c *WSClient) bool {
+	tookOver := false
+	for i, w := range h.waiting {
+		if w == old {
+			h.waiting[i] = c
+			h.scheduleBotFallback(c)
+			tookOver = true
+			break
+		}
+	}
+
+	if old.GameID != "" {
+		swap := func(inst *GameInstance) {
+			if inst.P1 == old {
+				inst.P1 = c
+			}
+			if inst.P2 == old {
+				inst.P2 = c
+			}
+		}
+		if inst, ok := h.games[old.GameID]; ok {
+			swap(inst)
+			c.GameID = old.GameID
+			h.sendJSON(c, WSMessage{
+				Type:   "start",
+				GameID: inst.Game.ID,
+				Payload: map[string]interface{}{
+					"player1": inst.Game.Player1,
+					"player2": inst.Game.Player2,
+					"board":   inst.Game.Board,
+					"turn":    inst.Game.CurrentPlayerName(),
+					"resumed": true,
+				},
+			})
+			inst.log.Info("session moved to new connection", "op", "session_replace", "username", c.Username)
+			tookOver = true
+		} else if inst, ok := h.resuming[old.GameID]; ok {
+			swap(inst)
+			c.GameID = old.GameID
+			h.sendJSON(c, WSMessage{Type: "waiting_resume", GameID: old.GameID})
+			tookOver = true
+		}
+	}
+
+	// Detach old before closing it so its disconnect does not forfeit the game
+	old.GameID = ""
+	h.releaseClient(old)
+	old.log.Info("session replaced by a new connection", "op", "session_replace")
+	closeMsg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session replaced")
+	if err := old.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(time.Second)); err != nil {
+		old.log.Warn("failed to send close frame", "op", "session_replace", "error", err)
+	}
+	old.Conn.Close()
+	return tookOver
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// totalsID is the _id of the one document in site_stats
+const totalsID = "totals"
+
+// RecordGameTotals adds a finished game to the site-wide counters
+func (db *MongoDB) RecordGameTotals(ctx context.Context, res GameResult) error {
+	_, err := db.Database.Collection("site_stats").UpdateOne(ctx,
+		bson.M{"_id": totalsID},
+		bson.M{
+			"$inc": bson.M{
+				"games":       1,
+				"draws":       boolToInt(res.Outcome == ResultDraw),
+				"bot_games":   boolToInt(res.VsBot),
+				"forfeits":    boolToInt(res.Forfeit),
+				"duration_ns": res.Duration,
+			},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// RebuildStats recomputes site_stats and player_stats from game_results.
+// Player stats depend on the order games were played in (streaks, ratings),
+// so results are replayed oldest first. Run it while no server is writing
+// results, otherwise games finishing meanwhile may be counted twice.
+func (db *MongoDB) RebuildStats(ctx context.Context) error {
+	results := db.Database.Collection("game_results")
+
+	cursor, err := results.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":         totalsID,
+			"games":       bson.M{"$sum": 1},
+			"draws":       bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$outcome", ResultDraw}}, 1, 0}}},
+			"bot_games":   bson.M{"$sum": bson.M{"$cond": bson.A{"$vs_bot", 1, 0}}},
+			"forfeits":    bson.M{"$sum": bson.M{"$cond": bson.A{"$forfeit", 1, 0}}},
+			"duration_ns": bson.M{"$sum": "$duration"},
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	var totals []SiteTotals
+	if err := cursor.All(ctx, &totals); err != nil {
+		return err
+	}
+	t := SiteTotals{ID: totalsID}
+	if len(totals) > 0 {
+		t = totals[0]
+	}
+	t.UpdatedAt = time.Now()
+	_, err = db.Database.Collection("site_stats").ReplaceOne(ctx, bson.M{"_id": totalsID}, t, options.Replace().SetUpsert(true))
+	if err != nil {
+		return err
+	}
+	slog.Info("rebuilt site totals", "op", "backfill_stats", "games", t.Games)
+
+	if _, err := db.Database.Collection("player_stats").DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+	cursor, err = results.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	n := 0
+	for cursor.Next(ctx) {
+		var res GameResult
+		if err := cursor.Decode(&res); err != nil {
+			slog.Warn("skipping undecodable result", "op", "backfill_stats", "error", err)
+			continue
+		}
+		if err := db.RecordPlayerStats(ctx, res); err != nil {
+			return err
+		}
+		n++
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+	slog.Info("rebuilt player stats", "op", "backfill_stats", "results", n)
+	return nil
+}
+
+// registerStatsRoutes mounts /stats. It reads the counters kept by
+// RecordGameTotals, so its cost does not grow with history.
+func registerStatsRoutes(mux *http.ServeMux, db *MongoDB, hub *Hub) {
+	type Stats struct {
+		TotalPlayers       int64   `json:"total_players"`
+		TotalGames         int64   `json:"total_games"`
+		TotalDraws         int64   `json:"total_draws"`
+		BotGames           int64   `json:"bot_games"`
+		Forfeits           int64   `json:"forfeits"`
+		AvgDurationSeconds float64 `json:"avg_duration_seconds"`
+		LiveGames          int     `json:"live_games"`
+	}
+
+	mux.HandleFunc("/stats", withRequestLog("stats", func(w http.ResponseWriter, r *http.Request) {
+		reqLog := requestLogger(r, "stats")
+		s := Stats{LiveGames: hub.LiveGames()}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		var t SiteTotals
+		err := db.Database.Collection("site_stats").FindOne(ctx, bson.M{"_id": totalsID}).Decode(&t)
+		if err != nil && err != mongo.ErrNoDocuments {
+			reqLog.Error("stats query failed", "error", err)
+		}
+		s.TotalGames, s.TotalDraws, s.BotGames, s.Forfeits = t.Games, t.Draws, t.BotGames, t.Forfeits
+		if t.Games > 0 {
+			avg := t.DurationNS / time.Duration(t.Games)
+			s.AvgDurationSeconds = math.Round(avg.Seconds()*10) / 10
+		}
+
+		if s.TotalPlayers, err = db.Database.Collection("player_stats").EstimatedDocumentCount(ctx); err != nil {
+			reqLog.Error("player count failed", "error", err)
+		}
+		writeJSON(w, s)
+	}))
+}
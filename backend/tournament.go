@@ -0,0 +1,373 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tournament modes: every engine against every other, or the first engine
+// against each of the rest
+const (
+	ModeRoundRobin = "round-robin"
+	ModeGauntlet   = "gauntlet"
+)
+
+// TournamentConfig describes a bot-vs-bot tournament
+type TournamentConfig struct {
+	Engines  []string
+	Mode     string
+	Games    int           // games per pairing, split evenly between colors
+	Workers  int           // games played at once
+	Seed     uint64        // every engine's randomness follows from this
+	Budget   time.Duration // thinking time per move
+	Openings []Position    // games start from these in turn; none for the empty board
+	Book     *OpeningBook  // engines open from this book when set
+	Plies    int           // plies played from Book
+}
+
+// Pairing is the score of engine A against engine B, from A's side
+type Pairing struct {
+	A, B                string
+	Wins, Draws, Losses int
+	Forfeits            int // games lost by playing an illegal move, counted in Wins and Losses
+	thinkA, thinkB      time.Duration
+	movesA, movesB      int
+}
+
+// Games returns the number of games played
+func (p *Pairing) Games() int { return p.Wins + p.Draws + p.Losses }
+
+// EloDiff estimates how much stronger A is than B in Elo, with the margin of
+// a 95% confidence interval. A clean sweep gives an infinite difference.
+func (p *Pairing) EloDiff() (diff, margin float64) {
+	return eloDiff(p.Wins, p.Draws, p.Losses)
+}
+
+func eloDiff(wins, draws, losses int) (diff, margin float64) {
+	n := float64(wins + draws + losses)
+	if n == 0 {
+		return 0, math.Inf(1)
+	}
+	score := (float64(wins) + float64(draws)/2) / n
+	variance := (float64(wins)*(1-score)*(1-score) + float64(draws)*(0.5-score)*(0.5-score) + float64(losses)*score*score) / n
+	se := math.Sqrt(variance / n)
+	lo, hi := elo(score-1.96*se), elo(score+1.96*se)
+	return elo(score), (hi - lo) / 2
+}
+
+// elo converts an expected score into an Elo difference
+func elo(score float64) float64 {
+	switch {
+	case score <= 0:
+		return math.Inf(-1)
+	case score >= 1:
+		return math.Inf(1)
+	}
+	return -400 * math.Log10(1/score-1)
+}
+
+// EngineTotals sums an engine's games over all its pairings
+type EngineTotals struct {
+	Engine              string
+	Wins, Draws, Losses int
+	Moves               int
+	Think               time.Duration
+}
+
+// AvgThink returns the mean time the engine took per move
+func (t EngineTotals) AvgThink() time.Duration {
+	if t.Moves == 0 {
+		return 0
+	}
+	return t.Think / time.Duration(t.Moves)
+}
+
+// TournamentResult is every pairing of a finished tournament
+type TournamentResult struct {
+	Engines  []string
+	Pairings []*Pairing
+	Took     time.Duration
+}
+
+// tournamentGame is one game to play
+type tournamentGame struct {
+	pairing *Pairing
+	swapped bool // B plays first
+	opening *Position
+	seed    uint64
+}
+
+// gameOutcome is how a game went, from player 1's side
+type gameOutcome struct {
+	result  Result
+	forfeit bool
+	think   [2]time.Duration // by seat, player 1 first
+	moves   [2]int
+}
+
+// RunTournament plays every game of cfg and returns the results
+func RunTournament(cfg TournamentConfig) (*TournamentResult, error) {
+	if len(cfg.Engines) < 2 {
+		return nil, errors.New("a tournament needs at least two engines")
+	}
+	seen := map[string]bool{}
+	for _, name := range cfg.Engines {
+		if _, ok := botEngines[name]; !ok {
+			return nil, fmt.Errorf("unknown engine %q, choose from %s", name, strings.Join(BotEngineNames(), ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("engine %q is listed twice", name)
+		}
+		seen[name] = true
+	}
+	if cfg.Games < 1 {
+		return nil, errors.New("games must be at least 1")
+	}
+	if cfg.Budget <= 0 {
+		return nil, errors.New("budget must be positive")
+	}
+
+	res := &TournamentResult{Engines: cfg.Engines}
+	switch cfg.Mode {
+	case ModeRoundRobin:
+		for i, a := range cfg.Engines {
+			for _, b := range cfg.Engines[i+1:] {
+				res.Pairings = append(res.Pairings, &Pairing{A: a, B: b})
+			}
+		}
+	case ModeGauntlet:
+		for _, b := range cfg.Engines[1:] {
+			res.Pairings = append(res.Pairings, &Pairing{A: cfg.Engines[0], B: b})
+		}
+	default:
+		return nil, fmt.Errorf("mode %q is not %s or %s", cfg.Mode, ModeRoundRobin, ModeGauntlet)
+	}
+
+	// Both colors play each opening, so the games come in pairs that differ
+	// only in who moves first
+	seeds := rand.New(rand.NewPCG(cfg.Seed, 0))
+	var games []tournamentGame
+	for _, p := range res.Pairings {
+		for i := 0; i < cfg.Games; i++ {
+			game := tournamentGame{pairing: p, swapped: i%2 == 1, seed: seeds.Uint64()}
+			if len(cfg.Openings) > 0 {
+				game.opening = &cfg.Openings[i/2%len(cfg.Openings)]
+			}
+			games = append(games, game)
+		}
+	}
+
+	start := time.Now()
+	outcomes := make([]gameOutcome, len(games))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(1, cfg.Workers); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				outcomes[i] = playTournamentGame(cfg, games[i])
+			}
+		}()
+	}
+	for i := range games {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	res.Took = time.Since(start)
+
+	for i, game := range games {
+		out, p := outcomes[i], game.pairing
+		a, b := 0, 1 // seats of A and B
+		if game.swapped {
+			a, b = 1, 0
+		}
+		p.thinkA += out.think[a]
+		p.thinkB += out.think[b]
+		p.movesA += out.moves[a]
+		p.movesB += out.moves[b]
+		switch {
+		case out.result == ResultDraw:
+			p.Draws++
+		case (out.result == ResultP1Win) == (a == 0):
+			p.Wins++
+		default:
+			p.Losses++
+		}
+		if out.forfeit {
+			p.Forfeits++
+		}
+	}
+	return res, nil
+}
+
+// playTournamentGame plays one game to the end. A move into a full or
+// nonexistent column loses the game on the spot.
+func playTournamentGame(cfg TournamentConfig, game tournamentGame) gameOutcome {
+	seeds := rand.New(rand.NewPCG(game.seed, 1))
+	names := [2]string{game.pairing.A, game.pairing.B}
+	if game.swapped {
+		names[0], names[1] = names[1], names[0]
+	}
+	var engines [2]BotEngine
+	for i, name := range names {
+		e, _ := NewSeededBotEngine(name, seeds.Uint64())
+		if name != "random" {
+			e = WithOpeningBook(e, cfg.Book, cfg.Plies, rand.New(rand.NewPCG(seeds.Uint64(), 2)))
+		}
+		engines[i] = e
+	}
+
+	g := NewGame("", "p1", "p2")
+	if game.opening != nil {
+		g = NewGameFrom("", "p1", "p2", *game.opening)
+	}
+	var out gameOutcome
+	for !g.Finished {
+		pos := g.Position()
+		seat := int(pos.Turn) - 1
+		started := time.Now()
+		col := engines[seat].ChooseMove(pos, cfg.Budget)
+		out.think[seat] += time.Since(started)
+		out.moves[seat]++
+		if _, err := g.Drop(col, g.CurrentPlayerName()); err != nil {
+			out.result, out.forfeit = winFor(opponent(pos.Turn)), true
+			return out
+		}
+	}
+	out.result = g.Result
+	return out
+}
+
+// Totals sums each engine's games, in the order the engines were given
+func (r *TournamentResult) Totals() []EngineTotals {
+	totals := make([]EngineTotals, len(r.Engines))
+	index := map[string]int{}
+	for i, name := range r.Engines {
+		totals[i].Engine = name
+		index[name] = i
+	}
+	for _, p := range r.Pairings {
+		a, b := &totals[index[p.A]], &totals[index[p.B]]
+		a.Wins += p.Wins
+		a.Draws += p.Draws
+		a.Losses += p.Losses
+		a.Moves += p.movesA
+		a.Think += p.thinkA
+		b.Wins += p.Losses
+		b.Draws += p.Draws
+		b.Losses += p.Wins
+		b.Moves += p.movesB
+		b.Think += p.thinkB
+	}
+	return totals
+}
+
+// WriteReport prints the crosstable, each pairing's Elo difference and each
+// engine's totals
+func (r *TournamentResult) WriteReport(w io.Writer) error {
+	width := len("engine")
+	for _, name := range r.Engines {
+		width = max(width, len(name))
+	}
+	cell := func(p *Pairing, flip bool) string {
+		if p == nil {
+			return "-"
+		}
+		if flip {
+			return fmt.Sprintf("%d-%d-%d", p.Losses, p.Draws, p.Wins)
+		}
+		return fmt.Sprintf("%d-%d-%d", p.Wins, p.Draws, p.Losses)
+	}
+	find := func(a, b string) (*Pairing, bool) {
+		for _, p := range r.Pairings {
+			switch {
+			case p.A == a && p.B == b:
+				return p, false
+			case p.A == b && p.B == a:
+				return p, true
+			}
+		}
+		return nil, false
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Crosstable (wins-draws-losses of the row engine)\n")
+	fmt.Fprintf(&sb, "%-*s", width, "")
+	for _, name := range r.Engines {
+		fmt.Fprintf(&sb, "  %*s", max(len(name), 9), name)
+	}
+	sb.WriteString("\n")
+	for _, row := range r.Engines {
+		fmt.Fprintf(&sb, "%-*s", width, row)
+		for _, col := range r.Engines {
+			text := ""
+			if row != col {
+				text = cell(find(row, col))
+			}
+			fmt.Fprintf(&sb, "  %*s", max(len(col), 9), text)
+		}
+		sb.WriteString("\n")
+	}
+
+	sb.WriteString("\nPairings\n")
+	for _, p := range r.Pairings {
+		diff, margin := p.EloDiff()
+		fmt.Fprintf(&sb, "%s vs %s: +%d =%d -%d", p.A, p.B, p.Wins, p.Draws, p.Losses)
+		if p.Forfeits > 0 {
+			fmt.Fprintf(&sb, " (%d forfeits)", p.Forfeits)
+		}
+		fmt.Fprintf(&sb, ", Elo %s ± %s\n", formatElo(diff, true), formatElo(margin, false))
+	}
+
+	sb.WriteString("\nTotals\n")
+	fmt.Fprintf(&sb, "%-*s  %6s  %5s  %5s  %6s  %6s  %16s  %10s\n", width, "engine", "games", "wins", "draws", "losses", "score", "Elo vs field", "avg think")
+	for _, t := range r.Totals() {
+		games := t.Wins + t.Draws + t.Losses
+		score := 0.0
+		if games > 0 {
+			score = 100 * (float64(t.Wins) + float64(t.Draws)/2) / float64(games)
+		}
+		diff, margin := eloDiff(t.Wins, t.Draws, t.Losses)
+		fmt.Fprintf(&sb, "%-*s  %6d  %5d  %5d  %6d  %5.1f%%  %16s  %10s\n", width, t.Engine, games, t.Wins, t.Draws, t.Losses, score,
+			formatElo(diff, true)+" ± "+formatElo(margin, false), t.AvgThink().Round(time.Microsecond))
+	}
+	fmt.Fprintf(&sb, "\n%d games in %s\n", r.games(), r.Took.Round(time.Millisecond))
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func (r *TournamentResult) games() int {
+	n := 0
+	for _, p := range r.Pairings {
+		n += p.Games()
+	}
+	return n
+}
+
+func formatElo(v float64, signed bool) string {
+	switch {
+	case math.IsInf(v, 1) || math.IsNaN(v):
+		if signed {
+			return "+inf"
+		}
+		return "inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	// Rounding first keeps a small negative difference from printing as -0
+	if v = math.Round(v); v == 0 {
+		v = 0
+	}
+	if signed {
+		return fmt.Sprintf("%+.0f", v)
+	}
+	return fmt.Sprintf("%.0f", v)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestEloDiff(t *testing.T) {
+	tests := []struct {
+		name                string
+		wins, draws, losses int
+		diff                float64
+	}{
+		{"even", 5, 0, 5, 0},
+		{"all draws", 0, 8, 0, 0},
+		{"even with draws", 3, 4, 3, 0},
+		{"sweep", 10, 0, 0, math.Inf(1)},
+		{"swept", 0, 0, 10, math.Inf(-1)},
+		{"no games", 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, _ := eloDiff(tt.wins, tt.draws, tt.losses)
+			if diff != tt.diff {
+				t.Errorf("eloDiff(%d, %d, %d) = %v, want %v", tt.wins, tt.draws, tt.losses, diff, tt.diff)
+			}
+		})
+	}
+
+	if diff, _ := eloDiff(3, 0, 1); diff <= 0 {
+		t.Errorf("winning 3 of 4 gives %v, want a positive difference", diff)
+	}
+	last := math.Inf(1)
+	for _, n := range []int{1, 10, 100} {
+		diff, margin := eloDiff(6*n, 2*n, 2*n)
+		if want, _ := eloDiff(6, 2, 2); math.Abs(diff-want) > 1e-9 {
+			t.Errorf("%d times the games moved the difference to %v, want %v", n, diff, want)
+		}
+		if margin >= last {
+			t.Errorf("margin %v at %d games did not shrink from %v", margin, 10*n, last)
+		}
+		last = margin
+	}
+}
+
+func TestTotalsMirrorsB(t *testing.T) {
+	r := &TournamentResult{
+		Engines: []string{"a", "b", "c"},
+		Pairings: []*Pairing{
+			{A: "a", B: "b", Wins: 3, Draws: 1, Losses: 2, movesA: 40, movesB: 38, thinkA: 4 * time.Second, thinkB: 2 * time.Second},
+			{A: "a", B: "c", Wins: 1, Draws: 0, Losses: 5, movesA: 30, movesB: 31},
+		},
+	}
+	want := []EngineTotals{
+		{Engine: "a", Wins: 4, Draws: 1, Losses: 7, Moves: 70, Think: 4 * time.Second},
+		{Engine: "b", Wins: 2, Draws: 1, Losses: 3, Moves: 38, Think: 2 * time.Second},
+		{Engine: "c", Wins: 5, Draws: 0, Losses: 1, Moves: 31},
+	}
+	got := r.Totals()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("totals[%d] = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+// columnEngine always plays the same column, full or not
+type columnEngine struct{ col int }
+
+func (e columnEngine) Name() string                           { return "column" }
+func (e columnEngine) ChooseMove(Position, time.Duration) int { return e.col }
+
+func TestRunTournamentSwapsColors(t *testing.T) {
+	// Both engines fill column 0, so whoever moves first plays the seventh
+	// disc into a full column and forfeits: the second player always wins
+	for _, name := range []string{"test-column-a", "test-column-b"} {
+		botEngines[name] = func(*rand.Rand) BotEngine { return columnEngine{col: 0} }
+		defer delete(botEngines, name)
+	}
+	res, err := RunTournament(TournamentConfig{
+		Engines: []string{"test-column-a", "test-column-b"},
+		Mode:    ModeRoundRobin,
+		Games:   4,
+		Workers: 2,
+		Seed:    1,
+		Budget:  time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := res.Pairings[0]
+	if p.Wins != 2 || p.Losses != 2 || p.Draws != 0 || p.Forfeits != 4 {
+		t.Errorf("got +%d =%d -%d with %d forfeits, want +2 =0 -2 with 4: each engine moves first twice",
+			p.Wins, p.Draws, p.Losses, p.Forfeits)
+	}
+}
+
+func TestRunTournamentRepeatsWithSeed(t *testing.T) {
+	cfg := TournamentConfig{
+		Engines: []string{"random", "heuristic"},
+		Mode:    ModeRoundRobin,
+		Games:   4,
+		Workers: 2,
+		Seed:    42,
+		Budget:  10 * time.Millisecond,
+	}
+	run := func() Pairing {
+		res, err := RunTournament(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *res.Pairings[0]
+	}
+	first, second := run(), run()
+	if first.Games() != 4 {
+		t.Fatalf("played %d games, want 4", first.Games())
+	}
+	if first.Wins != second.Wins || first.Draws != second.Draws || first.Losses != second.Losses ||
+		first.movesA != second.movesA || first.movesB != second.movesB {
+		t.Errorf("same seed gave +%d =%d -%d (%d/%d moves), then +%d =%d -%d (%d/%d moves)",
+			first.Wins, first.Draws, first.Losses, first.movesA, first.movesB,
+			second.Wins, second.Draws, second.Losses, second.movesA, second.movesB)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/secure/precis"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	UsernameMinLen = 3
+	UsernameMaxLen = 20
+)
+
+// reservedUsernames have special meaning in game results or the UI. They are
+// compared by skeleton, so "B0T" and "Вот" are reserved too.
+var reservedUsernames = []string{
+	"bot", "draw", "guest", "admin", "administrator", "system", "server",
+	"root", "moderator", "null", "undefined", "anonymous", "player1", "player2",
+}
+
+var errUsernameReserved = errors.New("username is reserved")
+
+// ValidateUsername applies the username policy: 3-20 letters or digits plus
+// '_', '-' and '.', starting with a letter or digit, in a single script and
+// not confusable with a reserved name. It is used for registrations and for
+// the names guests pick.
+func ValidateUsername(name string) error {
+	n := utf8.RuneCountInString(name)
+	if n < UsernameMinLen || n > UsernameMaxLen {
+		return fmt.Errorf("username must be %d-%d characters", UsernameMinLen, UsernameMaxLen)
+	}
+	if _, err := precis.UsernameCasePreserved.String(name); err != nil {
+		return fmt.Errorf("username contains disallowed characters: %w", err)
+	}
+
+	var script *unicode.RangeTable
+	for i, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		case i > 0 && (r == '_' || r == '-' || r == '.'):
+		default:
+			return fmt.Errorf("username may not contain %q there", r)
+		}
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		s := scriptOf(r)
+		if script != nil && s != script {
+			return errors.New("username may not mix alphabets")
+		}
+		script = s
+	}
+
+	skel := usernameSkeleton(name)
+	for _, reserved := range reservedUsernames {
+		if skel == usernameSkeleton(reserved) {
+			return errUsernameReserved
+		}
+	}
+	return nil
+}
+
+// usernameSkeleton folds a name so that names which look alike collide: it
+// applies compatibility mapping, strips accents from Latin, Greek and
+// Cyrillic letters, lowercases and then maps common confusable letters to
+// their Latin lookalike. Lowercasing comes first so that case never changes
+// the skeleton. Registered usernames are unique by skeleton.
+func usernameSkeleton(name string) string {
+	var b strings.Builder
+	var prev rune
+	for _, r := range norm.NFKD.String(name) {
+		// Accents only matter as a disguise on alphabets with lookalikes;
+		// in other scripts they change the letter.
+		if unicode.Is(unicode.Mn, r) && unicode.In(prev, unicode.Latin, unicode.Greek, unicode.Cyrillic) {
+			continue
+		}
+		prev = r
+		r = unicode.ToLower(r)
+		if m, ok := confusables[r]; ok {
+			r = m
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	s = strings.ReplaceAll(s, "rn", "m")
+	s = strings.ReplaceAll(s, "vv", "w")
+	return s
+}
+
+// confusables maps lowercase characters that render like a Latin letter, in
+// either case, to that letter. Capital I, lowercase l, 1 and | are all one
+// vertical stroke, so they and their Cyrillic and Greek twins fold to 'l'.
+// It covers the lookalikes from Cyrillic, Greek and digits that are
+// realistic in a 20 character name, not the full Unicode table.
+var confusables = map[rune]rune{
+	// Digits and ASCII
+	'0': 'o', '1': 'l', '5': 's', 'i': 'l', '|': 'l',
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'l', 'ј': 'j',
+	'ѕ': 's', 'һ': 'h', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ӏ': 'l',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ζ': 'z', 'η': 'h', 'ι': 'l', 'κ': 'k',
+	'μ': 'm', 'ν': 'n', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'y', 'χ': 'x',
+}
+
+var scripts = []*unicode.RangeTable{
+	unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Arabic,
+	unicode.Hebrew, unicode.Han, unicode.Hiragana, unicode.Katakana,
+	unicode.Hangul, unicode.Devanagari, unicode.Thai,
+}
+
+// scriptOf returns the script table a letter belongs to. Japanese kana and
+// Han are mixed in practice, so they count as one script.
+func scriptOf(r rune) *unicode.RangeTable {
+	for _, s := range scripts {
+		if unicode.Is(s, r) {
+			if s == unicode.Hiragana || s == unicode.Katakana {
+				return unicode.Han
+			}
+			return s
+		}
+	}
+	return nil
+}
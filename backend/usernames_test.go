@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUsernameSkeletonCollisions(t *testing.T) {
+	tests := []struct {
+		name, a, b string
+		same       bool
+	}{
+		{"case", "Ivan", "ivan", true},
+		{"all caps", "IVAN", "ivan", true},
+		{"capital I and lowercase l", "Ivan", "lvan", true},
+		{"digit one", "1van", "ivan", true},
+		{"pipe", "|van", "Ivan", true},
+		{"digit zero", "b0b", "BOB", true},
+		{"cyrillic lowercase", "іvan", "ivan", true},
+		{"cyrillic capital", "Іvan", "ivan", true},
+		{"cyrillic word", "Вот", "bot", true},
+		{"greek capital iota", "Ιvan", "ivan", true},
+		{"greek word", "ΒΟΤ", "bot", true},
+		{"accents", "Jóse", "jose", true},
+		{"rn looks like m", "rnax", "max", true},
+		{"different names", "ivan", "iva", false},
+		{"different letters", "alice", "alica", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa, sb := usernameSkeleton(tt.a), usernameSkeleton(tt.b)
+			if (sa == sb) != tt.same {
+				t.Errorf("skeletons %q (%s) and %q (%s): same = %v, want %v", sa, tt.a, sb, tt.b, sa == sb, tt.same)
+			}
+		})
+	}
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		reserved bool
+		ok       bool
+	}{
+		{"plain", "alice", false, true},
+		{"with punctuation", "alice_b-c.d", false, true},
+		{"reserved", "bot", true, false},
+		{"reserved in caps", "BOT", true, false},
+		{"reserved with a digit", "B0T", true, false},
+		{"reserved in cyrillic", "Вот", true, false},
+		{"reserved with capital I", "ADMIN", true, false},
+		{"reserved with digit one", "adm1n", true, false},
+		{"reserved draw", "DRAW", true, false},
+		{"too short", "ab", false, false},
+		{"too long", "abcdefghijklmnopqrstu", false, false},
+		{"leading punctuation", "_alice", false, false},
+		{"space", "al ice", false, false},
+		{"mixed scripts", "alicе", false, false},
+		{"colon", "bot:x", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateUsername(tt.username)
+			if (err == nil) != tt.ok {
+				t.Fatalf("ValidateUsername(%q) = %v, want ok %v", tt.username, err, tt.ok)
+			}
+			if got := errors.Is(err, errUsernameReserved); got != tt.reserved {
+				t.Errorf("ValidateUsername(%q) = %v, want reserved %v", tt.username, err, tt.reserved)
+			}
+		})
+	}
+}
@@ -39,9 +39,26 @@ export default function App() {
     case "start": {
       setGameId(msg.gameId);
       setStatus("playing");
-      setBoard(Array(6).fill(0).map(() => Array(7).fill(0)));
+      setBoard(msg.payload?.board || Array(6).fill(0).map(() => Array(7).fill(0)));
       setWinningCells([]);
-      setStatusMessage("Game started! Make your move.");
+      setStatusMessage(
+        msg.payload?.resumed ? "Game resumed! Pick up where you left off." : "Game started! Make your move."
+      );
+      break;
+    }
+
+    case "waiting_resume": {
+      setGameId(msg.gameId);
+      setStatus("waiting");
+      setStatusMessage("Waiting for your opponent to rejoin the suspended game...");
+      break;
+    }
+
+    case "server_shutdown": {
+      const seconds = msg.payload?.drain_seconds;
+      setStatusMessage(
+        `Server is restarting. Finish within ${seconds}s or the game will be saved for later.`
+      );
       break;
     }
 
@@ -7,7 +7,7 @@ export default function Leaderboard({ refreshTrigger = 0 }) {
   useEffect(() => {
     fetch("http://localhost:8080/leaderboard")
       .then((res) => res.json())
-      .then((data) => setList(Array.isArray(data) ? data : [])) // ✅ ensure it's an array
+      .then((data) => setList(Array.isArray(data?.entries) ? data.entries : [])) // ✅ ensure it's an array
       .catch((err) => console.error("Leaderboard fetch error:", err));
   }, [refreshTrigger]);
 
@@ -21,7 +21,7 @@ export default function Leaderboard({ refreshTrigger = 0 }) {
         <>
           <ol>
             {displayedList.map((l, idx) => (
-              <li key={idx}>
+              <li key={l.player_id ?? idx}>
                 {l.username} — {l.wins} wins
               </li>
             ))}